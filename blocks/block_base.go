@@ -13,6 +13,7 @@ type BlockBase struct {
   Protocol string `json:"protocol"`
   Setup string `json:"setup"`
   Round uint64 `json:"round"`
+  Version uint32 `json:"version"` /* 0 for version 1 blocks */
 }
 
 func (b *BlockBase) Base() *BlockBase {
@@ -34,5 +35,8 @@ func (b *BlockBase) marshalBase() j.IObject {
     res.Prop("setup", j.String(b.Setup))
   }
   res.Prop("round", j.Uint64(b.Round))
+  if b.Version != 0 {
+    res.Prop("version", j.Uint32(b.Version))
+  }
   return res
 }
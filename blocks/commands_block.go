@@ -2,6 +2,7 @@
 package blocks
 
 import (
+  "bytes"
   "encoding/json"
   "strings"
   "path/filepath"
@@ -24,16 +25,14 @@ func (b *CommandBlock) Marshal() j.IObject {
 
 func (svc *Service) MakeCommandBlock(parentHash string, commands []byte) (hash string, err error) {
 
-  commands, err = j.PrettyBytes(commands)
-  if err != nil { return }
-
-  block := CommandBlock{
-    Commands: hashResource(commands),
-  }
+  var block CommandBlock
   err = svc.chainBlock(&block.BlockBase, "command", parentHash)
   if err != nil { return }
+  commands, err = formatResource(block.FormatVersion(), commands)
+  if err != nil { return }
+  block.Commands = hashResource(commands)
   encodedBlock := block.Marshal()
-  hash, err = svc.writeBlock(encodedBlock)
+  hash, err = svc.writeBlock(block.FormatVersion(), encodedBlock)
   if os.IsExist(err) { return hash, nil }
   if err != nil { return }
   defer func () {
@@ -101,16 +100,87 @@ func (svc *Service) CheckCommands(block *BlockBase, commands string) (result []b
     return
   }
 
-  var res struct {
-    Commands json.RawMessage `json:"commands"`
-    Error string `json:"error"`
-    Details string `json:"details"`
-  }
+  var res checkOutcome
   json.Unmarshal(cmd.Stdout.Bytes(), &res)
+  return res.result()
+}
+
+type checkOutcome struct {
+  Commands json.RawMessage `json:"commands"`
+  Error string `json:"error"`
+  Details string `json:"details"`
+}
+
+func (res *checkOutcome) result() ([]byte, error) {
   if res.Error != "" {
-    err = errors.Errorf("%s\n%s", res.Error, res.Details)
-    return
+    return nil, errors.Errorf("%s\n%s", res.Error, res.Details)
   }
-
   return res.Commands, nil
 }
+
+/* Outcome of checking one of the command texts passed to CheckCommandsBatch. */
+type CheckResult struct {
+  Commands []byte
+  Err error
+}
+
+/*
+  Checks several command texts against the same block.  Task tools that
+  implement check_commands_batch (reading a JSON array of texts, writing
+  {"results": [{commands, error, details}]}) are run once; other task tools
+  are run once per text, as with CheckCommands.  A task tool is known not
+  to implement it once it has rejected the subcommand.
+*/
+func (svc *Service) CheckCommandsBatch(block *BlockBase, commands []string) ([]CheckResult, error) {
+
+  if block.Protocol == "" {
+    return nil, errors.New("block has protocol")
+  }
+
+  results := make([]CheckResult, len(commands))
+  if _, ok := svc.noBatchCheck.Load(block.Task); !ok && len(commands) > 1 {
+    texts := make([]string, len(commands))
+    for i, text := range commands {
+      texts[i] = strings.Replace(text, "\r\n", "\n", -1)
+    }
+    input, err := json.Marshal(texts)
+    if err != nil { return nil, errors.Wrap(err, 0) }
+    cmd := newCommand(
+      svc.taskToolsPath(block.Task),
+      "-t", svc.blockDir(block.Task),
+      "-p", svc.blockDir(block.Protocol),
+      "check_commands_batch")
+    err = cmd.Run(bytes.NewReader(input))
+    var res struct {
+      Results []checkOutcome `json:"results"`
+    }
+    if err == nil {
+      err = json.Unmarshal(cmd.Stdout.Bytes(), &res)
+    }
+    if err == nil && len(res.Results) == len(commands) {
+      for i := range res.Results {
+        results[i].Commands, results[i].Err = res.Results[i].result()
+      }
+      return results, nil
+    }
+    /* Other failures may be transient: check the texts one by one this
+       time only. */
+    if err != nil && isUnknownSubcommand(cmd, "check_commands_batch") {
+      svc.noBatchCheck.Store(block.Task, true)
+    }
+  }
+
+  for i, text := range commands {
+    results[i].Commands, results[i].Err = svc.CheckCommands(block, text)
+  }
+  return results, nil
+}
+
+/* Reports whether a task tool exited because it does not know the
+   subcommand it was given.  Argument parsers name the subcommand they
+   reject ("unknown command 'x'", "invalid choice: 'x'"). */
+func isUnknownSubcommand(cmd *command, name string) bool {
+  state := cmd.cmd.ProcessState
+  if state == nil || state.Success() { return false }
+  return cmd.Stdout.Len() == 0 && strings.Contains(cmd.Stderr.String(), name)
+}
@@ -0,0 +1,74 @@
+/*
+
+  Block formats
+
+  A block's hash is the SHA-1 (base64url) of its block.json file.
+
+  Version 1 blocks (which have no "version" property) are written by
+  jase.ToPrettyBytes, and their params.json and commands.json resources are
+  reformatted by jase.PrettyBytes before being hashed.  Their hashes thus
+  depend on the details of the pretty-printer, which must not change.
+
+  Version 2 blocks have "version": 2 and are written in the RFC 8785
+  canonical form (jase.JCS), as are their JSON resources, so that their
+  hashes can be recomputed by any JCS implementation.  JCS reads numbers as
+  doubles, so resources with integers that a double cannot hold exactly
+  (above 2^53) are rejected rather than silently rounded.
+
+  Existing blocks are never rewritten, so their hashes stay valid.  A block
+  has the version of its parent, so that a chain (and the games played on
+  it) keeps a single format; only task blocks, which start chains, use the
+  configured version (blocks.format_version).  Version 2 is opt-in: the
+  default stays 1 so that a deployment does not start chains that older
+  instances cannot read; set format_version to 2 once all instances run a
+  release that reads version 2 blocks.
+
+*/
+
+package blocks
+
+import (
+  "github.com/go-errors/errors"
+  j "tezos-contests.izibi.com/backend/jase"
+)
+
+const (
+  BlockVersion1 = 1
+  BlockVersion2 = 2
+  LatestBlockVersion = BlockVersion2
+)
+
+/* Format version of a block (version 1 blocks have no version property). */
+func (b *BlockBase) FormatVersion() uint32 {
+  if b.Version == 0 { return BlockVersion1 }
+  return b.Version
+}
+
+/* Format version of new chains. */
+func (svc *Service) formatVersion() uint32 {
+  if svc.config.Blocks.FormatVersion == 0 { return BlockVersion1 }
+  return svc.config.Blocks.FormatVersion
+}
+
+func encodeBlock(version uint32, block j.Value) ([]byte, error) {
+  switch version {
+  case BlockVersion1:
+    return j.ToPrettyBytes(block)
+  case BlockVersion2:
+    return j.ToJCSBytes(block)
+  }
+  return nil, errors.Errorf("unknown block version %d", version)
+}
+
+/* Formats a JSON resource (params, commands) of a block before it is
+   hashed and saved. */
+func formatResource(version uint32, resource []byte) ([]byte, error) {
+  switch version {
+  case BlockVersion1:
+    return j.PrettyBytes(resource)
+  case BlockVersion2:
+    if err := j.CheckExactIntegers(resource); err != nil { return nil, err }
+    return j.JCS(resource)
+  }
+  return nil, errors.Errorf("unknown block version %d", version)
+}
@@ -6,7 +6,6 @@ import (
   "encoding/json"
   "fmt"
   "github.com/go-errors/errors"
-  "github.com/json-iterator/go"
   j "tezos-contests.izibi.com/backend/jase"
 )
 
@@ -21,14 +20,14 @@ var PageIndexExpiry = 24 * time.Hour
 func (svc *Service) GetHeadIndex(gameKey string, lastBlock string) (uint64, []byte, error) {
   var err error
   var key = headIndexKey(gameKey)
-  bs, _ := svc.redis.Get(key).Bytes()
-  if len(bs) != 0 {
+  cached, _ := svc.cache.Get(key)
+  if len(cached) != 0 {
     type HeadIndex struct {
       Page uint64 `json:"page"`
       Blocks json.RawMessage `json:"blocks"`
     }
     var index HeadIndex
-    err = json.Unmarshal(bs, &index)
+    err = json.Unmarshal([]byte(cached), &index)
     if err != nil { return 0, nil, errors.Wrap(err, 0) }
     return index.Page, []byte(index.Blocks), nil
   }
@@ -39,24 +38,22 @@ func (svc *Service) GetHeadIndex(gameKey string, lastBlock string) (uint64, []by
   obj.Prop("blocks", j.Raw(blocks))
   objBytes, err := j.ToBytes(obj)
   if err != nil { return 0, nil, errors.Wrap(err, 0) }
-  err = svc.redis.Set(key, objBytes, HeadIndexExpiry).Err()
-  if err != nil { return 0, nil, errors.Wrap(err, 0) }
+  err = svc.cache.Set(key, string(objBytes), HeadIndexExpiry)
+  if err != nil { return 0, nil, err }
   return page, blocks, nil
 }
 
 func (svc *Service) ClearHeadIndex(lastBlock string) error {
-  err := svc.redis.Del(headIndexKey(lastBlock)).Err()
-  if err != nil { return errors.Wrap(err, 0) }
-  return nil
+  return svc.cache.Del(headIndexKey(lastBlock))
 }
 
 func (svc *Service) GetPageIndex(gameKey string, lastBlock string, page uint64) ([]byte, error) {
   var err error
   /* Is the page index in the cache? */
   var pageKey = pageIndexKey(gameKey, page)
-  bs, _ := svc.redis.Get(pageKey).Bytes()
+  bs, _ := svc.cache.Get(pageKey)
   if len(bs) != 0 {
-    return bs, nil
+    return []byte(bs), nil
   }
   /* Starting with the HEAD index, use the page's first block as the parent of
      the last block of the preceding page, until we reach the requested page. */
@@ -68,14 +65,14 @@ func (svc *Service) GetPageIndex(gameKey string, lastBlock string, page uint64)
   for page < nextPage {
     nextPage--
     pageKey = pageIndexKey(gameKey, nextPage)
-    cached, _ := svc.redis.Get(pageKey).Bytes()
-    if len(bs) != 0 {
-      blocks = cached
+    cached, _ := svc.cache.Get(pageKey)
+    if len(cached) != 0 {
+      blocks = []byte(cached)
     } else {
-      parentHash := jsoniter.Get(blocks, 0, "hash").ToString()
+      parentHash, _ := j.GetString(blocks, 0, "hash")
       blocks, err = svc.buildPageIndex(parentHash)
       if err != nil { return nil, err }
-      err = svc.redis.Set(pageKey, blocks, PageIndexExpiry).Err()
+      err = svc.cache.Set(pageKey, string(blocks), PageIndexExpiry)
       if err != nil { return nil, err }
     }
   }
@@ -6,9 +6,7 @@ import (
   "io"
   "regexp"
   "strings"
-  "unsafe"
   "github.com/go-errors/errors"
-  "github.com/json-iterator/go"
   j "tezos-contests.izibi.com/backend/jase"
 )
 
@@ -78,21 +76,15 @@ func findLastState(r io.Reader) ([]byte, error) {
   scanner := bufio.NewScanner(r)
   for scanner.Scan() {
     line := scanner.Bytes()
-    message := jsoniter.Get(line, "message")
-    if message.LastError() == nil && message.Get("type").ToString() == "state" {
-      /* XXX (*objectLazyAny)ToString uses a runtime trick to avoid copying
-         the underlying bytes; we use the same trick to recover an alias to
-         the same array (keeping in mind the original slice's capacity is lost).
-         Is there a better way to do this?
-       */
-      state = veryUnsafeStringToByteSlice(message.Get("state").ToString())
+    typ, err := j.GetString(line, "message", "type")
+    if err == nil && typ == "state" {
+      raw, err := j.Get(line, "message", "state")
+      if err != nil { return nil, errors.Wrap(err, 0) }
+      /* The scanner reuses its buffer, keep a copy. */
+      state = append(state[:0], raw...)
     }
   }
   err = scanner.Err()
   if err != nil { return nil, errors.Wrap(err, 0) }
   return state, nil
 }
-
-func veryUnsafeStringToByteSlice(bs string) []byte {
-  return *(*[]byte)(unsafe.Pointer(&bs))
-}
@@ -54,6 +54,10 @@ func (svc *Service) chainBlock(dst *BlockBase, kind string, parentHash string) e
   (*dst).Kind = kind
   (*dst).Sequence = parentBase.Sequence + 1
   (*dst).Parent = parentHash
+  if kind == "task" {
+    (*dst).Version = svc.formatVersion()
+    if (*dst).Version == BlockVersion1 { (*dst).Version = 0 }
+  }
   switch parentBase.Kind {
     case "task":
       (*dst).Task = parentHash
@@ -73,8 +77,8 @@ func (svc *Service) chainBlock(dst *BlockBase, kind string, parentHash string) e
   return nil
 }
 
-func (svc *Service) writeBlock(block j.Value) (hash string, err error) {
-  blockBytes, err := j.ToPrettyBytes(block)
+func (svc *Service) writeBlock(version uint32, block j.Value) (hash string, err error) {
+  blockBytes, err := encodeBlock(version, block)
   if err != nil { err = errors.Wrap(err, 0); return }
   hash = hashBlock(blockBytes)
   blockDir := svc.blockDir(hash)
@@ -133,3 +137,16 @@ func (svc *Service) ReadResource(hash string, filename string) ([]byte, error) {
   if err != nil { return nil, errors.Wrap(err, 0) }
   return bs, nil
 }
+
+/* BlockSize returns the number of bytes used by a block's files. */
+func (svc *Service) BlockSize(hash string) (int64, error) {
+  if !validateHash(hash) { return 0, errors.New("invalid hash") }
+  var size int64
+  err := filepath.Walk(svc.blockDir(hash), func (path string, info os.FileInfo, err error) error {
+    if err != nil { return err }
+    if !info.IsDir() { size += info.Size() }
+    return nil
+  })
+  if err != nil { return 0, errors.Wrap(err, 0) }
+  return size, nil
+}
@@ -37,7 +37,7 @@ func (svc *Service) MakeProtocolBlock(parentHash string, intf, impl []byte) (has
   err = svc.chainBlock(&block.BlockBase, "protocol", parentHash)
   if err != nil { return }
   encodedBlock := block.Marshal()
-  hash, err = svc.writeBlock(encodedBlock)
+  hash, err = svc.writeBlock(block.FormatVersion(), encodedBlock)
   if os.IsExist(err) { return hash, nil }
   if err != nil { return }
   defer func () {
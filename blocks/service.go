@@ -3,17 +3,19 @@ package blocks
 
 import (
   "path/filepath"
-  "github.com/go-redis/redis"
+  "sync"
   "tezos-contests.izibi.com/backend/config"
+  "tezos-contests.izibi.com/backend/pubsub"
 )
 
 type Service struct {
   config *config.Config
-  redis *redis.Client
+  cache pubsub.Store
+  noBatchCheck sync.Map /* task hashes whose tools lack check_commands_batch */
 }
 
-func NewService(cfg *config.Config, rc *redis.Client) *Service {
-  return &Service{cfg, rc}
+func NewService(cfg *config.Config, cache pubsub.Store) *Service {
+  return &Service{config: cfg, cache: cache}
 }
 
 func (svc *Service) taskToolsPath(taskBlockHash string) string {
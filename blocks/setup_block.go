@@ -23,16 +23,14 @@ func (b *SetupBlock) Marshal() j.IObject {
 
 func (svc *Service) MakeSetupBlock(parentHash string, params []byte) (hash string, err error) {
 
-  params, err = j.PrettyBytes(params)
-  if err != nil { err = errors.Wrap(err, 0); return }
-
-  block := SetupBlock{
-    Params: hashResource(params),
-  }
+  var block SetupBlock
   err = svc.chainBlock(&block.BlockBase, "setup", parentHash)
   if err != nil { return }
+  params, err = formatResource(block.FormatVersion(), params)
+  if err != nil { err = errors.Wrap(err, 0); return }
+  block.Params = hashResource(params)
   encodedBlock := block.Marshal()
-  hash, err = svc.writeBlock(encodedBlock)
+  hash, err = svc.writeBlock(block.FormatVersion(), encodedBlock)
   if os.IsExist(err) { return hash, nil }
   if err != nil { return }
   defer func () {
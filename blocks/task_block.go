@@ -30,7 +30,7 @@ func (svc *Service) MakeTaskBlock(parentHash string, identifier string, revision
   if err != nil { return }
   encodedBlock := block.Marshal()
   fmt.Printf("TASK %v\n", block)
-  hash, err = svc.writeBlock(encodedBlock)
+  hash, err = svc.writeBlock(block.FormatVersion(), encodedBlock)
   if os.IsExist(err) { return hash, nil }
   if err != nil { return }
 
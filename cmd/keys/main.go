@@ -0,0 +1,139 @@
+/*
+  Manages team key pairs and signs requests, for testing the signed API
+  without tc-node.  Key files hold the KeyPair JSON used by tc-node.
+
+    keys [-key key.json] generate          writes a new key pair
+    keys [-key key.json] show-public       prints the public key
+    keys [-key key.json] register          prints the /Teams/:teamId/Update
+                                           body setting the public key
+    keys [-key key.json] [-legacy] sign [request.json]
+                                           signs a request (stdin if no file)
+    keys verify [request.json]             verifies a signed request
+
+  The API key used by sign and verify is read from the -api-key flag or the
+  API_KEY environment variable.
+*/
+
+package main
+
+import (
+  "errors"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "github.com/json-iterator/go"
+  j "tezos-contests.izibi.com/backend/jase"
+  "tezos-contests.izibi.com/backend/signing"
+)
+
+var keyPath = flag.String("key", "key.json", "path of the key pair file")
+var apiKey = flag.String("api-key", os.Getenv("API_KEY"), "API key (default $API_KEY)")
+var algorithm = flag.String("algorithm", "ed25519", "algorithm of generated keys")
+var legacy = flag.Bool("legacy", false, "sign with the version 1 (pretty-printed) format")
+
+func readKeyPair() (*signing.KeyPair, error) {
+  f, err := os.Open(*keyPath)
+  if err != nil { return nil, err }
+  defer f.Close()
+  return signing.ReadKeyPair(f)
+}
+
+/* Reads the file given as argument, or stdin. */
+func readInput() ([]byte, error) {
+  if flag.NArg() > 1 {
+    return ioutil.ReadFile(flag.Arg(1))
+  }
+  return ioutil.ReadAll(os.Stdin)
+}
+
+func generate() error {
+  if _, err := os.Stat(*keyPath); err == nil {
+    return fmt.Errorf("%s already exists", *keyPath)
+  }
+  kp, err := signing.NewKeyPairWith(*algorithm)
+  if err != nil { return err }
+  bs, err := kp.Encode()
+  if err != nil { return err }
+  err = ioutil.WriteFile(*keyPath, append(bs, '\n'), 0600)
+  if err != nil { return err }
+  fmt.Println(kp.Public)
+  return nil
+}
+
+func showPublic() error {
+  kp, err := readKeyPair()
+  if err != nil { return err }
+  fmt.Println(kp.Public)
+  return nil
+}
+
+func register() error {
+  kp, err := readKeyPair()
+  if err != nil { return err }
+  body := j.Object()
+  body.Prop("publicKey", j.String(kp.Public))
+  bs, err := j.ToPrettyBytes(body)
+  if err != nil { return err }
+  fmt.Println(string(bs))
+  return nil
+}
+
+/* The author is set to the key's public key if the request has none. */
+func sign() error {
+  kp, err := readKeyPair()
+  if err != nil { return err }
+  if *apiKey == "" { return errors.New("missing API key") }
+  message, err := readInput()
+  if err != nil { return err }
+  var req map[string]jsoniter.RawMessage
+  err = jsoniter.Unmarshal(message, &req)
+  if err != nil { return err }
+  if _, ok := req["author"]; !ok {
+    req["author"], _ = jsoniter.Marshal("@" + kp.Public)
+    message, err = jsoniter.Marshal(req)
+    if err != nil { return err }
+  }
+  var signed []byte
+  if *legacy {
+    signed, err = signing.SignLegacy(kp.Private, *apiKey, message)
+  } else {
+    signed, err = signing.Sign(kp.Private, *apiKey, message)
+  }
+  if err != nil { return err }
+  fmt.Println(string(signed))
+  return nil
+}
+
+func verify() error {
+  if *apiKey == "" { return errors.New("missing API key") }
+  message, err := readInput()
+  if err != nil { return err }
+  err = signing.Verify(*apiKey, message)
+  if err != nil { return err }
+  fmt.Println("OK")
+  return nil
+}
+
+func main() {
+  var err error
+  flag.Parse()
+  switch flag.Arg(0) {
+  case "generate":
+    err = generate()
+  case "show-public":
+    err = showPublic()
+  case "register":
+    err = register()
+  case "sign":
+    err = sign()
+  case "verify":
+    err = verify()
+  default:
+    err = errors.New("usage: keys [flags] generate|show-public|register|sign|verify")
+  }
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "error: %v\n", err)
+    os.Exit(1)
+  }
+}
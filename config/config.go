@@ -11,8 +11,10 @@ type Config struct {
   FrontendOrigin string `yaml:"frontend_origin"`
   ApiVersion string `yaml:"api_version"`
   ApiKey string `yaml:"api_key"`
+  ServerKey ServerKeyConfig `yaml:"server_key"`
   Auth AuthConfig `yaml:"auth"`
   Blocks BlocksConfig `yaml:"blocks"`
+  PubSub PubSubConfig `yaml:"pubsub"`
   LogFile string `yaml:"log_file"`
   Production bool `yaml:"production"`
 }
@@ -33,4 +35,19 @@ type BlocksConfig struct {
   TaskToolsCmd string `yaml:"task_tools_cmd"`
   TaskHelperCmd string `yaml:"task_helper_cmd"`
   SkipDelete bool `yaml:"skip_delete"`
+  FormatVersion uint32 `yaml:"format_version"` /* of new chains, see blocks/format.go */
+}
+
+type PubSubConfig struct {
+  Backend string `yaml:"backend"` /* "redis" (default) or "memory" (single node) */
+  RedisAddr string `yaml:"redis_addr"`
+  RedisPassword string `yaml:"redis_password"`
+  RedisDb int `yaml:"redis_db"`
+}
+
+/* The backend's own key pair (as generated by signing.NewKeyPair), used to
+   sign receipts. */
+type ServerKeyConfig struct {
+  Public string `yaml:"public"`
+  Private string `yaml:"private"`
 }
@@ -0,0 +1,10 @@
+
+-- +migrate Up
+
+CREATE FULLTEXT INDEX ix_chains__title_description ON chains (title, description_text);
+CREATE INDEX ix_chains__contest_id_updated_at USING btree ON chains (contest_id, updated_at);
+
+-- +migrate Down
+
+DROP INDEX ix_chains__title_description ON chains;
+DROP INDEX ix_chains__contest_id_updated_at ON chains;
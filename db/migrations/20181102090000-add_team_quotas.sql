@@ -0,0 +1,15 @@
+
+-- +migrate Up
+
+-- NULL quotas are unlimited.
+ALTER TABLE contests ADD COLUMN max_private_chains INT NULL DEFAULT NULL;
+ALTER TABLE contests ADD COLUMN max_running_games INT NULL DEFAULT NULL;
+ALTER TABLE contests ADD COLUMN max_store_bytes BIGINT NULL DEFAULT NULL;
+ALTER TABLE teams ADD COLUMN store_bytes BIGINT NOT NULL DEFAULT 0;
+
+-- +migrate Down
+
+ALTER TABLE contests DROP COLUMN max_private_chains;
+ALTER TABLE contests DROP COLUMN max_running_games;
+ALTER TABLE contests DROP COLUMN max_store_bytes;
+ALTER TABLE teams DROP COLUMN store_bytes;
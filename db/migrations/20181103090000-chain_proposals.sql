@@ -0,0 +1,50 @@
+
+-- +migrate Up
+
+CREATE TABLE chain_proposals (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    contest_id BIGINT NOT NULL,
+    team_id BIGINT NOT NULL,
+    source_chain_id BIGINT NOT NULL,
+    target_chain_id BIGINT NOT NULL,
+    title TEXT NOT NULL DEFAULT "",
+    description_text TEXT NOT NULL DEFAULT "",
+    protocol_hash VARCHAR(27) NOT NULL,
+    status ENUM('open', 'accepted', 'rejected') NOT NULL DEFAULT 'open',
+    resolved_at DATETIME NULL DEFAULT NULL,
+    resolved_by BIGINT NULL DEFAULT NULL,
+    PRIMARY KEY (id)
+) CHARACTER SET utf8 ENGINE=InnoDB;
+CREATE INDEX ix_chain_proposals__source_chain_id USING btree ON chain_proposals (source_chain_id);
+CREATE INDEX ix_chain_proposals__target_chain_id USING btree ON chain_proposals (target_chain_id);
+
+ALTER TABLE chain_proposals ADD CONSTRAINT fk_chain_proposals__contest_id
+    FOREIGN KEY (contest_id) REFERENCES contests(id) ON DELETE CASCADE;
+ALTER TABLE chain_proposals ADD CONSTRAINT fk_chain_proposals__team_id
+    FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE CASCADE;
+ALTER TABLE chain_proposals ADD CONSTRAINT fk_chain_proposals__source_chain_id
+    FOREIGN KEY (source_chain_id) REFERENCES chains(id) ON DELETE CASCADE;
+ALTER TABLE chain_proposals ADD CONSTRAINT fk_chain_proposals__target_chain_id
+    FOREIGN KEY (target_chain_id) REFERENCES chains(id) ON DELETE CASCADE;
+
+CREATE TABLE chain_proposal_comments (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    proposal_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    body_text TEXT NOT NULL,
+    PRIMARY KEY (id)
+) CHARACTER SET utf8 ENGINE=InnoDB;
+CREATE INDEX ix_chain_proposal_comments__proposal_id USING btree ON chain_proposal_comments (proposal_id);
+
+ALTER TABLE chain_proposal_comments ADD CONSTRAINT fk_chain_proposal_comments__proposal_id
+    FOREIGN KEY (proposal_id) REFERENCES chain_proposals(id) ON DELETE CASCADE;
+ALTER TABLE chain_proposal_comments ADD CONSTRAINT fk_chain_proposal_comments__user_id
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+-- +migrate Down
+
+DROP TABLE chain_proposal_comments;
+DROP TABLE chain_proposals;
@@ -0,0 +1,42 @@
+-- +migrate Up
+
+CREATE TABLE team_webhooks (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    team_id BIGINT NOT NULL,
+    url VARCHAR(1024) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    event_types VARCHAR(1024) NOT NULL DEFAULT "",
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    PRIMARY KEY (id)
+) CHARACTER SET utf8 ENGINE=InnoDB;
+CREATE INDEX ix_team_webhooks__team_id USING btree ON team_webhooks (team_id);
+
+ALTER TABLE team_webhooks ADD CONSTRAINT fk_team_webhooks__team_id
+    FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE CASCADE;
+
+CREATE TABLE webhook_deliveries (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    webhook_id BIGINT NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload MEDIUMTEXT NOT NULL,
+    status ENUM('pending', 'delivered', 'failed') NOT NULL DEFAULT 'pending',
+    nb_attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NULL DEFAULT NULL,
+    last_attempt_at DATETIME NULL DEFAULT NULL,
+    last_status_code INT NULL DEFAULT NULL,
+    last_error TEXT NOT NULL DEFAULT "",
+    PRIMARY KEY (id)
+) CHARACTER SET utf8 ENGINE=InnoDB;
+CREATE INDEX ix_webhook_deliveries__webhook_id USING btree ON webhook_deliveries (webhook_id, id);
+CREATE INDEX ix_webhook_deliveries__status_next_attempt_at USING btree ON webhook_deliveries (status, next_attempt_at);
+
+ALTER TABLE webhook_deliveries ADD CONSTRAINT fk_webhook_deliveries__webhook_id
+    FOREIGN KEY (webhook_id) REFERENCES team_webhooks(id) ON DELETE CASCADE;
+
+-- +migrate Down
+
+DROP TABLE webhook_deliveries;
+DROP TABLE team_webhooks;
@@ -0,0 +1,8 @@
+-- +migrate Up
+
+-- Set on shutdown for games whose next block was still being computed.
+ALTER TABLE games ADD COLUMN needs_recovery TINYINT(1) NOT NULL DEFAULT 0;
+
+-- +migrate Down
+
+ALTER TABLE games DROP COLUMN needs_recovery;
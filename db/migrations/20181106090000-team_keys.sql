@@ -0,0 +1,51 @@
+-- +migrate Up
+
+-- Wrapped keys are longer than 64 characters for some algorithms
+-- (about 99 for ecdsa-p256).
+ALTER TABLE teams MODIFY COLUMN `public_key` VARCHAR(128) NOT NULL DEFAULT "";
+
+CREATE TABLE team_keys (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    team_id BIGINT NOT NULL,
+    public_key VARCHAR(128) NOT NULL,
+    label VARCHAR(255) NOT NULL DEFAULT "",
+    expires_at DATETIME NULL DEFAULT NULL,
+    revoked_at DATETIME NULL DEFAULT NULL,
+    PRIMARY KEY (id)
+) CHARACTER SET utf8 ENGINE=InnoDB;
+CREATE UNIQUE INDEX ix_team_keys__public_key USING btree ON team_keys (public_key);
+CREATE INDEX ix_team_keys__team_id USING btree ON team_keys (team_id);
+
+ALTER TABLE team_keys ADD CONSTRAINT fk_team_keys__team_id
+    FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE CASCADE;
+
+-- The key of each team that is not deleted becomes its first key.  Keys
+-- were not unique among teams: a key shared by several teams goes to the
+-- most recently created one.
+INSERT INTO team_keys (team_id, public_key, label)
+    SELECT MAX(id), public_key, "primary" FROM teams
+    WHERE public_key <> "" AND deleted_at IS NULL
+    GROUP BY public_key;
+
+-- Audit trail of signed game requests.
+CREATE TABLE game_actions (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    game_key VARCHAR(43) NOT NULL,
+    team_id BIGINT NOT NULL,
+    team_key_id BIGINT NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    PRIMARY KEY (id)
+) CHARACTER SET utf8 ENGINE=InnoDB;
+CREATE INDEX ix_game_actions__team_id USING btree ON game_actions (team_id, id);
+CREATE INDEX ix_game_actions__game_key USING btree ON game_actions (game_key, id);
+
+ALTER TABLE game_actions ADD CONSTRAINT fk_game_actions__team_key_id
+    FOREIGN KEY (team_key_id) REFERENCES team_keys(id) ON DELETE CASCADE;
+
+-- +migrate Down
+
+DROP TABLE game_actions;
+DROP TABLE team_keys;
+ALTER TABLE teams MODIFY COLUMN `public_key` VARCHAR(64) NOT NULL DEFAULT "";
@@ -0,0 +1,17 @@
+-- +migrate Up
+
+CREATE TABLE game_receipts (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    game_key VARCHAR(43) NOT NULL,
+    round BIGINT NOT NULL,
+    kind ENUM('commands', 'block') NOT NULL,
+    team_id BIGINT NULL DEFAULT NULL,
+    receipt MEDIUMTEXT NOT NULL,
+    PRIMARY KEY (id)
+) CHARACTER SET utf8 ENGINE=InnoDB;
+CREATE INDEX ix_game_receipts__game_key USING btree ON game_receipts (game_key, round, id);
+
+-- +migrate Down
+
+DROP TABLE game_receipts;
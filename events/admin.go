@@ -0,0 +1,180 @@
+/*
+  Stream inspection for administrators.
+
+  Each instance reports the streams it controls and counts, per channel,
+  the events it published and those it delivered to its clients.  The
+  counters of a channel are dropped once it has had no events for
+  ChannelStatsExpiry.
+*/
+
+package events
+
+import (
+  "sort"
+  "sync"
+  "time"
+  "github.com/gin-gonic/gin"
+  j "tezos-contests.izibi.com/backend/jase"
+  "tezos-contests.izibi.com/backend/auth"
+  "tezos-contests.izibi.com/backend/utils"
+  "tezos-contests.izibi.com/backend/view"
+)
+
+var ChannelStatsExpiry = 1 * time.Hour
+
+type channelStats struct {
+  mutex sync.Mutex
+  published map[string]uint64
+  delivered map[string]uint64
+  updatedAt map[string]time.Time
+}
+
+func newChannelStats() *channelStats {
+  return &channelStats{
+    published: make(map[string]uint64),
+    delivered: make(map[string]uint64),
+    updatedAt: make(map[string]time.Time),
+  }
+}
+
+func (cs *channelStats) countPublished(channel string) {
+  cs.mutex.Lock()
+  cs.published[channel] += 1
+  cs.updatedAt[channel] = time.Now()
+  cs.mutex.Unlock()
+}
+
+func (cs *channelStats) countDelivered(channel string) {
+  cs.mutex.Lock()
+  cs.delivered[channel] += 1
+  cs.updatedAt[channel] = time.Now()
+  cs.mutex.Unlock()
+}
+
+/* Drops the counters of the channels that have had no events recently. */
+func (cs *channelStats) prune(now time.Time) {
+  cs.mutex.Lock()
+  for channel, updatedAt := range cs.updatedAt {
+    if now.Sub(updatedAt) > ChannelStatsExpiry {
+      delete(cs.published, channel)
+      delete(cs.delivered, channel)
+      delete(cs.updatedAt, channel)
+    }
+  }
+  cs.mutex.Unlock()
+}
+
+func (cs *channelStats) view() j.Value {
+  cs.mutex.Lock()
+  defer cs.mutex.Unlock()
+  var channels []string
+  for channel := range cs.published {
+    channels = append(channels, channel)
+  }
+  for channel := range cs.delivered {
+    if _, ok := cs.published[channel]; !ok {
+      channels = append(channels, channel)
+    }
+  }
+  sort.Strings(channels)
+  items := j.Array()
+  for _, channel := range channels {
+    item := j.Object()
+    item.Prop("channel", j.String(channel))
+    item.Prop("published", j.Uint64(cs.published[channel]))
+    item.Prop("delivered", j.Uint64(cs.delivered[channel]))
+    items.Item(item)
+  }
+  return items
+}
+
+func (st *stream) view(active bool, now time.Time) j.Value {
+  obj := j.Object()
+  obj.Prop("key", j.String(st.key))
+  obj.Prop("active", j.Boolean(active))
+  if st.userId != 0 {
+    obj.Prop("userId", j.String(view.ExportId(st.userId)))
+  }
+  if st.teamId != 0 {
+    obj.Prop("teamId", j.String(view.ExportId(st.teamId)))
+  }
+  st.mutex.Lock()
+  var channels []string
+  for channel := range st.cursor {
+    channels = append(channels, channel)
+  }
+  lastEventId := encodeCursor(st.cursor)
+  nbPending := len(st.pending)
+  connectedAt := st.connectedAt
+  st.mutex.Unlock()
+  sort.Strings(channels)
+  subs := j.Array()
+  for _, channel := range channels {
+    subs.Item(j.String(channel))
+  }
+  obj.Prop("subscriptions", subs)
+  obj.Prop("lastEventId", j.String(lastEventId))
+  obj.Prop("pending", j.Int(nbPending))
+  if active {
+    obj.Prop("connectedFor", j.Int(int(now.Sub(connectedAt).Seconds())))
+  } else {
+    st.svc.mutex.RLock()
+    idleSince := st.idleSince
+    st.svc.mutex.RUnlock()
+    obj.Prop("idleFor", j.Int(int(now.Sub(idleSince).Seconds())))
+  }
+  return obj
+}
+
+func (svc *Service) checkAdmin(c *gin.Context, r *utils.Response) bool {
+  userId, ok := auth.GetUserId(c)
+  if !ok { r.BadUser(); return false }
+  if !svc.model.IsUserAdmin(userId) { r.StringError("Not Authorized"); return false }
+  return true
+}
+
+func (svc *Service) RouteAdmin(router gin.IRoutes) {
+
+  /* Lists the streams controlled by this instance, and its channel
+     counters. */
+  router.GET("/Admin/Events", func (c *gin.Context) {
+    r := utils.NewResponse(c)
+    if !svc.checkAdmin(c, r) { return }
+    now := time.Now()
+    var active, idle []*stream
+    svc.mutex.RLock()
+    for _, st := range svc.streams {
+      active = append(active, st)
+    }
+    for _, st := range svc.idleStreams {
+      idle = append(idle, st)
+    }
+    svc.mutex.RUnlock()
+    streams := j.Array()
+    for _, st := range active {
+      streams.Item(st.view(true, now))
+    }
+    for _, st := range idle {
+      streams.Item(st.view(false, now))
+    }
+    result := j.Object()
+    result.Prop("instance", j.String(svc.config.SelfUrl))
+    result.Prop("streams", streams)
+    result.Prop("channels", svc.stats.view())
+    r.Result(result)
+  })
+
+  /* Disconnects the client of a stream and deletes the stream, on any
+     instance. */
+  router.POST("/Admin/Events/:key/Close", func (c *gin.Context) {
+    r := utils.NewResponse(c)
+    if !svc.checkAdmin(c, r) { return }
+    st, err := svc.lookupStream(c.Param("key"))
+    if err != nil { r.Error(err); return }
+    if st == nil { r.StringError("not found"); return }
+    err = svc.dropStream(st)
+    if err != nil { r.Error(err); return }
+    r.Ok()
+  })
+
+}
@@ -6,16 +6,69 @@ package events
 
 import (
   "fmt"
+  "strconv"
+  "strings"
+  "github.com/go-errors/errors"
 )
 
+var ErrChannelDenied = errors.New("access denied")
+var ErrChannelUnknown = errors.New("unknown channel")
+
 func (svc *Service) getContestChannel(contestId int64) (string, error) {
   return fmt.Sprintf("contest:%d", contestId), nil
 }
 
 func (svc *Service) getTeamChannel(teamId int64) (string, error) {
-  return fmt.Sprint("team:%d", teamId), nil
+  return fmt.Sprintf("team:%d", teamId), nil
 }
 
 func (svc *Service) getGameChannel(gameKey string) (string, error) {
   return fmt.Sprintf("game:%s", gameKey), nil
 }
+
+/*
+  Checks that a stream's owner (a user or a team) may receive the messages
+  posted to a channel.  Returns nil if the subscription is allowed,
+  ErrChannelDenied or ErrChannelUnknown if it is refused, and any other
+  error if the check could not be performed.
+*/
+func (svc *Service) authorizeChannel(st *stream, channel string) error {
+  var err error
+  var ok bool
+  if st.userId == 0 && st.teamId == 0 { return ErrChannelDenied }
+  parts := strings.SplitN(channel, ":", 2)
+  if len(parts) != 2 || len(parts[1]) == 0 { return ErrChannelUnknown }
+  switch parts[0] {
+  case "contest":
+    contestId, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil { return ErrChannelUnknown }
+    if st.teamId != 0 {
+      team, err := svc.model.LoadTeam(st.teamId)
+      if err != nil { return err }
+      ok = team.Contest_id == contestId
+    } else {
+      ok, err = svc.model.CanUserAccessContest(st.userId, contestId)
+      if err != nil { return err }
+    }
+  case "team":
+    teamId, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil { return ErrChannelUnknown }
+    if st.teamId != 0 {
+      ok = st.teamId == teamId
+    } else {
+      ok, err = svc.model.IsUserInTeam(st.userId, teamId)
+      if err != nil { return err }
+    }
+  case "game":
+    if st.teamId != 0 {
+      ok, err = svc.model.CanTeamViewGame(st.teamId, parts[1])
+    } else {
+      ok, err = svc.model.CanUserViewGame(st.userId, parts[1])
+    }
+    if err != nil { return err }
+  default:
+    return ErrChannelUnknown
+  }
+  if !ok { return ErrChannelDenied }
+  return nil
+}
@@ -0,0 +1,87 @@
+/*
+  Coordination between server instances.
+
+  Each instance listens on a redis control channel named after its url.
+  An instance that receives a request for a stream controlled by another
+  instance relays it on that instance's control channel, and an instance
+  that takes over a stream (because the client reconnected to it) tells the
+  previous controller to release its copy.
+*/
+
+package events
+
+import (
+  "encoding/json"
+  "fmt"
+  "github.com/go-errors/errors"
+)
+
+type controlMessage struct {
+  Op string `json:"op"` /* "subscribe", "unsubscribe", "filters", "close", "release" */
+  Key string `json:"key"`
+  Channels []string `json:"channels,omitempty"`
+}
+
+func controlChannel(serverUrl string) string {
+  return fmt.Sprintf("server:%s", serverUrl)
+}
+
+func (svc *Service) sendControl(serverUrl string, msg *controlMessage) error {
+  payload, err := json.Marshal(msg)
+  if err != nil { return errors.Wrap(err, 0) }
+  err = svc.backend.Publish(controlChannel(serverUrl), string(payload))
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (svc *Service) handleControl(payload string) error {
+  var err error
+  var msg controlMessage
+  err = json.Unmarshal([]byte(payload), &msg)
+  if err != nil { return errors.Wrap(err, 0) }
+  if msg.Op == "release" {
+    svc.releaseStream(msg.Key)
+    return nil
+  }
+  st, err := svc.getStream(msg.Key)
+  if err != nil { return err }
+  switch msg.Op {
+  case "subscribe":
+    err = st.subscribeLocal(msg.Channels...)
+  case "unsubscribe":
+    err = st.unsubscribeLocal(msg.Channels...)
+  case "filters":
+    err = st.reloadFilters()
+  case "close":
+    st.Close()
+  default:
+    return errors.Errorf("unknown control op %s", msg.Op)
+  }
+  if err != nil { return err }
+  return nil
+}
+
+/* Drops the local copy of a stream now controlled by another instance (or
+   deleted by an administrator).  The redis keys are left untouched as they
+   belong to the new controller. */
+func (svc *Service) releaseStream(key string) {
+  var st *stream
+  var ok bool
+  svc.mutex.Lock()
+  st, ok = svc.streams[key]
+  if !ok {
+    st, ok = svc.idleStreams[key]
+  }
+  if ok {
+    st.released = true
+    delete(svc.streams, key)
+    delete(svc.idleStreams, key)
+  }
+  svc.mutex.Unlock()
+  if !ok { return }
+  if verbose {
+    hi2.Printf("stream %s released\n", key)
+  }
+  st.Close()
+  _ = st.pubSub.Close()
+}
@@ -4,29 +4,27 @@ package events
 import (
   "bytes"
   "fmt"
-  "strconv"
   "strings"
   j "tezos-contests.izibi.com/backend/jase"
 )
 
 type Encoder struct {
-  lastId uint64
-  lastEvent string
+  lastId string
 }
 
 func NewEncoder() *Encoder {
-  return &Encoder{0, "message"}
+  return &Encoder{""}
 }
 
 func (enc *Encoder) Encode(m *SSEvent) []byte {
   var buf bytes.Buffer
-  if m.Id != 0 && m.Id != enc.lastId {
-    buf.WriteString(fmt.Sprintf("id: %s\n", strconv.FormatUint(m.Id, 10)))
+  if m.Id != "" && m.Id != enc.lastId {
+    buf.WriteString(fmt.Sprintf("id: %s\n", noLF(m.Id)))
     enc.lastId = m.Id
   }
-  if m.Event != "" && m.Event != enc.lastEvent {
+  /* Unlike the id, the event type only applies to the message it is in. */
+  if m.Event != "" && m.Event != "message" {
     buf.WriteString(fmt.Sprintf("event: %s\n", noLF(m.Event)))
-    enc.lastEvent = m.Event
   }
   if len(m.Data) > 0 {
     lines := strings.Split(m.Data, "\n")
@@ -0,0 +1,183 @@
+/*
+  Stream filters.
+
+  A subscription can restrict the events delivered on a channel to a set of
+  event types and, on game channels, to the events of a minimum round or
+  that involve a given player rank.  Events that do not carry a round (or
+  ranks) are not concerned by the corresponding criterion.
+  The filters of a stream are stored in redis alongside its subscriptions,
+  so that they survive a reconnection to another instance.
+  Filtered events still advance the stream's cursor: the client is sent
+  their id (with no data) so that its Last-Event-ID stays current.
+*/
+
+package events
+
+import (
+  "encoding/json"
+  "fmt"
+  "strings"
+  "github.com/go-errors/errors"
+  "tezos-contests.izibi.com/backend/pubsub"
+)
+
+var ErrFilterUnsupported = errors.New("filter not supported on this channel")
+
+type Filter struct {
+  Types []string `json:"types,omitempty"`
+  MinRound uint64 `json:"minRound,omitempty"`
+  Rank *uint32 `json:"rank,omitempty"`
+}
+
+/*
+  An entry of the subscribe list of a subscription request: either a
+  channel name, or an object holding the channel and the filter criteria,
+  for example {"channel": "game:xyz", "types": ["block"], "rank": 2}.
+*/
+type subscription struct {
+  Channel string
+  Filter *Filter
+}
+
+func (sub *subscription) UnmarshalJSON(data []byte) error {
+  var err error
+  if len(data) != 0 && data[0] == '"' {
+    sub.Filter = nil
+    return json.Unmarshal(data, &sub.Channel)
+  }
+  var obj struct {
+    Channel string `json:"channel"`
+    Filter
+  }
+  err = json.Unmarshal(data, &obj)
+  if err != nil { return err }
+  sub.Channel = obj.Channel
+  sub.Filter = &obj.Filter
+  if len(obj.Types) == 0 && obj.MinRound == 0 && obj.Rank == nil {
+    sub.Filter = nil
+  }
+  return nil
+}
+
+/* Fields of event bodies that filters look at. */
+type filterFields struct {
+  Round *uint64 `json:"round"`
+  Ranks *[]uint32 `json:"ranks"`
+}
+
+func (f *Filter) Validate(channel string) error {
+  if (f.MinRound != 0 || f.Rank != nil) && !strings.HasPrefix(channel, "game:") {
+    return ErrFilterUnsupported
+  }
+  return nil
+}
+
+func (f *Filter) Accepts(typ string, body string) bool {
+  if len(f.Types) != 0 {
+    found := false
+    for _, t := range f.Types {
+      if t == typ { found = true; break }
+    }
+    if !found { return false }
+  }
+  if f.MinRound == 0 && f.Rank == nil { return true }
+  var fields filterFields
+  if body != "" {
+    err := json.Unmarshal([]byte(body), &fields)
+    if err != nil { return true }
+  }
+  if f.MinRound != 0 && fields.Round != nil && *fields.Round < f.MinRound {
+    return false
+  }
+  if f.Rank != nil && fields.Ranks != nil {
+    found := false
+    for _, rank := range *fields.Ranks {
+      if rank == *f.Rank { found = true; break }
+    }
+    if !found { return false }
+  }
+  return true
+}
+
+/* Returns false if the event is excluded by the filter on its channel.
+   Events not read from history (such as "system" messages) always pass. */
+func (st *stream) accepts(event *SSEvent) bool {
+  if event.channel == "" { return true }
+  st.mutex.Lock()
+  filter := st.filters[event.channel]
+  st.mutex.Unlock()
+  if filter == nil { return true }
+  return filter.Accepts(event.typ, event.body)
+}
+
+/*
+  Sets or (with a nil filter) clears the filters of the given channels.
+  If the stream is controlled by another instance, it is told to reload
+  the stream's filters.
+*/
+func (st *stream) SetFilters(filters map[string]*Filter) error {
+  var err error
+  current, err := st.svc.loadFilters(st.key)
+  if err != nil { return err }
+  for channel, filter := range filters {
+    if filter == nil {
+      delete(current, channel)
+    } else {
+      current[channel] = filter
+    }
+  }
+  err = st.svc.saveFilters(st.key, current)
+  if err != nil { return err }
+  if st.pubSub == nil {
+    return st.svc.sendControl(st.serverUrl, &controlMessage{Op: "filters", Key: st.key})
+  }
+  st.setFiltersLocal(current)
+  return nil
+}
+
+/* Removes the stored filters of channels the stream unsubscribes from. */
+func (svc *Service) dropFilters(key string, channels []string) error {
+  filters, err := svc.loadFilters(key)
+  if err != nil { return err }
+  if len(filters) == 0 { return nil }
+  for _, channel := range channels {
+    delete(filters, channel)
+  }
+  return svc.saveFilters(key, filters)
+}
+
+func (st *stream) setFiltersLocal(filters map[string]*Filter) {
+  st.mutex.Lock()
+  st.filters = filters
+  st.mutex.Unlock()
+}
+
+func (st *stream) reloadFilters() error {
+  filters, err := st.svc.loadFilters(st.key)
+  if err != nil { return err }
+  st.setFiltersLocal(filters)
+  return nil
+}
+
+func (svc *Service) loadFilters(key string) (map[string]*Filter, error) {
+  filters := make(map[string]*Filter)
+  data, err := svc.backend.Get(streamFiltersKey(key))
+  if err == pubsub.ErrNil { return filters, nil }
+  if err != nil { return nil, err }
+  err = json.Unmarshal([]byte(data), &filters)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return filters, nil
+}
+
+func (svc *Service) saveFilters(key string, filters map[string]*Filter) error {
+  if len(filters) == 0 {
+    return svc.backend.Del(streamFiltersKey(key))
+  }
+  data, err := json.Marshal(filters)
+  if err != nil { return errors.Wrap(err, 0) }
+  return svc.backend.Set(streamFiltersKey(key), string(data), RedisStreamKeyExpiry)
+}
+
+func streamFiltersKey(key string) string {
+  return fmt.Sprintf("stream:%s:filters", key)
+}
@@ -0,0 +1,171 @@
+/*
+  Event history.
+
+  Messages posted to a channel are appended to a log (a redis stream of
+  bounded length, which expires once the channel is quiet) and a notification
+  is published on the channel.  A client event stream keeps a cursor holding,
+  for each channel it subscribes to, the id of the last entry delivered.  The encoded cursor is used as the SSE
+  event id, so that a client reconnecting with a Last-Event-ID header (to
+  any instance, even after a restart) resumes exactly where it left off.
+*/
+
+package events
+
+import (
+  "fmt"
+  "net/url"
+)
+
+/* Approximate number of entries kept in the history of each channel. */
+var EventHistoryMaxLen int64 = 1000
+
+/* The history of a channel is dropped once no entry was appended for this
+   long: a stream can be resumed while it is idle, and then until its keys
+   expire, after which its cursor is not used again. */
+var EventHistoryExpiry = MaxStreamIdleDuration + RedisStreamKeyExpiry
+
+/* Maximum number of entries read from a channel's history at once. */
+var EventHistoryBatchSize int64 = 100
+
+func historyKey(channel string) string {
+  return fmt.Sprintf("history:%s", channel)
+}
+
+func (svc *Service) appendEvent(channel string, event *Event) error {
+  values, err := event.entryValues()
+  if err != nil { return err }
+  key := historyKey(channel)
+  id, err := svc.backend.XAdd(key, EventHistoryMaxLen, values)
+  if err != nil { return err }
+  err = svc.backend.Expire(key, EventHistoryExpiry)
+  if err != nil { return err }
+  svc.stats.countPublished(channel)
+  /* Wake up the streams subscribed to the channel. */
+  return svc.backend.Publish(channel, id)
+}
+
+/* Returns the id of the last entry in a channel's history. */
+func (svc *Service) historyTail(channel string) (string, error) {
+  return svc.backend.XTail(historyKey(channel))
+}
+
+func encodeCursor(cursor map[string]string) string {
+  values := url.Values{}
+  for channel, id := range cursor {
+    values.Set(channel, id)
+  }
+  return values.Encode()
+}
+
+/* Invalid cursors (such as those sent by older clients) decode as empty. */
+func decodeCursor(s string) map[string]string {
+  cursor := make(map[string]string)
+  values, err := url.ParseQuery(s)
+  if err != nil { return cursor }
+  for channel := range values {
+    cursor[channel] = values.Get(channel)
+  }
+  return cursor
+}
+
+/* Starts delivering the events of the given channels, from the current end
+   of their history. */
+func (st *stream) watch(channels ...string) error {
+  var err error
+  for _, channel := range channels {
+    st.mutex.Lock()
+    _, found := st.cursor[channel]
+    st.mutex.Unlock()
+    if found { continue }
+    var tail string
+    tail, err = st.svc.historyTail(channel)
+    if err != nil { return err }
+    st.mutex.Lock()
+    if _, found = st.cursor[channel]; !found {
+      st.cursor[channel] = tail
+    }
+    st.mutex.Unlock()
+  }
+  return nil
+}
+
+func (st *stream) unwatch(channels ...string) {
+  st.mutex.Lock()
+  for _, channel := range channels {
+    delete(st.cursor, channel)
+    delete(st.dirty, channel)
+    delete(st.filters, channel)
+  }
+  st.mutex.Unlock()
+}
+
+/* Called when a client (re)connects.  The positions found in the client's
+   last event id take precedence over the stream's own cursor, and all
+   channels are checked for events the client has not received. */
+func (st *stream) resume(lastEventId string) {
+  clientCursor := decodeCursor(lastEventId)
+  st.mutex.Lock()
+  for channel := range st.cursor {
+    if id, ok := clientCursor[channel]; ok {
+      st.cursor[channel] = id
+    }
+    st.dirty[channel] = true
+  }
+  st.pending = nil
+  st.mutex.Unlock()
+}
+
+/* Marks a channel as having new entries.  Returns false if the stream does
+   not follow the channel's history. */
+func (st *stream) notify(channel string) bool {
+  st.mutex.Lock()
+  defer st.mutex.Unlock()
+  if _, ok := st.cursor[channel]; !ok { return false }
+  st.dirty[channel] = true
+  return true
+}
+
+func (st *stream) lastEventId() string {
+  st.mutex.Lock()
+  defer st.mutex.Unlock()
+  return encodeCursor(st.cursor)
+}
+
+/* Returns the next event to send to the client, reading from the history
+   of the channels that have been notified.  Returns nil if there is none. */
+func (st *stream) Next() (*SSEvent, error) {
+  for {
+    st.mutex.Lock()
+    if len(st.pending) != 0 {
+      event := st.pending[0]
+      st.pending = st.pending[1:]
+      st.mutex.Unlock()
+      return event, nil
+    }
+    var channel, after string
+    for channel = range st.dirty { break }
+    after = st.cursor[channel]
+    st.mutex.Unlock()
+    if channel == "" { return nil, nil }
+    entries, err := st.svc.backend.XRead(historyKey(channel), after, EventHistoryBatchSize)
+    if err != nil { return nil, err }
+    st.mutex.Lock()
+    if st.cursor[channel] == after {
+      for _, entry := range entries {
+        st.cursor[channel] = entry.Id
+        event, err := entryToSSEvent(channel, entry.Values, st.legacy)
+        if err != nil {
+          /* Skip the entry rather than blocking the stream on it. */
+          hi2.Printf("bad event %s in %s: %v\n", entry.Id, channel, err)
+          continue
+        }
+        event.Id = encodeCursor(st.cursor)
+        st.pending = append(st.pending, event)
+      }
+      if int64(len(entries)) < EventHistoryBatchSize {
+        delete(st.dirty, channel)
+      }
+    }
+    st.mutex.Unlock()
+  }
+}
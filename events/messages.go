@@ -3,27 +3,27 @@ package events
 
 type contestMessage struct {
   contestId int64
-  payload string
+  event *Event
 }
 
 type teamMessage struct {
   teamId int64
-  payload string
+  event *Event
 }
 
 type gameMessage struct {
   gameKey string
-  payload string
+  event *Event
 }
 
-func (svc *Service) PostContestMessage(contestId int64, payload string) {
-  svc.channel <- &contestMessage{contestId: contestId, payload: payload}
+func (svc *Service) PostContestEvent(contestId int64, event *Event) {
+  svc.channel <- &contestMessage{contestId: contestId, event: event}
 }
 
-func (svc *Service) PostTeamMessage(teamId int64, payload string) {
-  svc.channel <- &teamMessage{teamId: teamId, payload: payload}
+func (svc *Service) PostTeamEvent(teamId int64, event *Event) {
+  svc.channel <- &teamMessage{teamId: teamId, event: event}
 }
 
-func (svc *Service) PostGameMessage(gameKey string, payload string) {
-  svc.channel <- &gameMessage{gameKey: gameKey, payload: payload}
+func (svc *Service) PostGameEvent(gameKey string, event *Event) {
+  svc.channel <- &gameMessage{gameKey: gameKey, event: event}
 }
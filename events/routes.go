@@ -2,8 +2,8 @@
 package events
 
 import (
+  "fmt"
   "io"
-  //"fmt"
   "time"
   "github.com/gin-gonic/gin"
   j "tezos-contests.izibi.com/backend/jase"
@@ -25,12 +25,14 @@ func (svc *Service) Wrap(c *gin.Context) *Context {
 
 func (svc *Service) Route(router gin.IRoutes) {
 
+  svc.RouteAdmin(router)
+
 /*
   go func() {
     i := 0
     for {
       time.Sleep(100 * time.Millisecond)
-      _ = svc.redis.Publish("system", fmt.Sprintf("%d", i)).Err()
+      _ = svc.backend.Publish("system", fmt.Sprintf("%d", i))
       i += 1
     }
   }()
@@ -71,12 +73,15 @@ func (svc *Service) Route(router gin.IRoutes) {
     receiving events.
     If the "Last-Event-ID" header contains the Id of the last event received
     by the client, no events will be missed.
+    Events are sent as typed JSON objects, or as the untyped strings used by
+    older clients if the "format" query parameter is "legacy".
   */
   router.GET("/Events/:key", func (c *gin.Context) {
     st, ok, err := svc.connectStream(c.Param("key"), c.GetHeader("Last-Event-ID"))
     if err != nil { c.AbortWithError(500, err); return }
     if st == nil { c.String(404, "Not Found"); return }
     if !ok { c.String(400, "Bad Request"); return }
+    st.legacy = c.Query("format") == "legacy"
     c.Header("Content-Type", "text/event-stream")
     c.Header("Cache-Control", "no-cache")
     c.Header("Connection", "keep-alive")
@@ -94,16 +99,26 @@ func (svc *Service) Route(router gin.IRoutes) {
       var err error
       if !initDone {
         /* Send an initial chunk to cause the status and header to be sent
-           immediately to the client. */
-        w.Write([]byte("\nretry: 500\nid: 1\n"))
+           immediately to the client.  The id gives the client a position
+           to resume from even if it receives no events. */
+        w.Write([]byte(fmt.Sprintf("\nretry: 500\nid: %s\n\n", st.lastEventId())))
         initDone = true
         return true
       }
       var event *SSEvent
-      event = st.Resend()
+      event, err = st.Next()
+      if err != nil { cleanup(); return false }
       if event != nil {
+        if !st.accepts(event) {
+          /* Only send the id, so that the client's Last-Event-ID moves past
+             the filtered event. */
+          event = &SSEvent{Id: event.Id}
+        }
         _, err = w.Write(encoder.Encode(event))
         if err != nil { cleanup(); return false }
+        if event.channel != "" {
+          svc.stats.countDelivered(event.channel)
+        }
         return true
       }
       select {
@@ -119,75 +134,89 @@ func (svc *Service) Route(router gin.IRoutes) {
             cleanup()
             return false
           }
-          event = st.Push(encodeMessage(msg.Channel, msg.Payload))
+          if st.notify(msg.Channel) {
+            /* The events are read from the channel's history. */
+            return true
+          }
+          /* Messages on other channels (such as "system") are not kept. */
+          event = &SSEvent{Event: "message", Data: encodeMessage(msg.Channel, msg.Payload)}
           _, err = w.Write(encoder.Encode(event))
           if err != nil { cleanup(); return false }
+          svc.stats.countDelivered(msg.Channel)
           return true
         case <-st.closeChan:
           cleanup()
           return false
+        case <-svc.shutdown:
+          w.Write([]byte(fmt.Sprintf("retry: %d\n\n", svc.shutdownRetryDelay())))
+          cleanup()
+          return false
       }
     })
   })
 
-  router.POST("/Events/:key/Close", func (c *gin.Context) {
-    ctx := svc.Wrap(c)
-    st, err := svc.getStream(c.Param("key"))
-    if err != nil { ctx.resp.Error(err); return }
-    if st == nil { ctx.resp.StringError("not found"); return }
-    st.closeChan <- true
-    time.Sleep(100 * time.Millisecond)
-    err = svc.redis.Publish("system", "HELLO!").Err()
-    ctx.resp.Result(j.Boolean(true))
-  })
-
   /*
     This route enables a client to manage the channel subscriptions of an event
     stream.
-    XXX As is, this API allows spying on any contest or team, by guessing their
-    id.  Either add permission checking on the channels, or use secret keys
-    for contest and team channels.
+    Each channel in the subscribe list is checked against the rights of the
+    stream's owner; the result lists, for each of them, whether it was
+    subscribed to or the reason it was rejected.
+    An entry of the subscribe list is either a channel name or an object
+    {channel, types?, minRound?, rank?} restricting the events delivered on
+    the channel (minRound and rank only apply to game channels).
   */
   router.POST("/Events/:key", func (c *gin.Context) {
     ctx := svc.Wrap(c)
     var err error
     var req struct {
-      Subscribe []string `json:"subscribe"`
+      Subscribe []subscription `json:"subscribe"`
       Unsubscribe []string `json:"unsubscribe"`
     }
     err = c.BindJSON(&req)
     if err != nil { ctx.resp.Error(err); return }
-    key := c.Param("key")
+    /* If the stream is controlled by another instance, the changes are
+       relayed to it through redis. */
     var st *stream
-    st, err = svc.getStream(key)
+    st, err = svc.lookupStream(c.Param("key"))
     if err != nil { ctx.resp.Error(err); return }
-    if st.pubSub == nil {
-      if len(st.serverUrl) == 0 {
-        ctx.resp.StringError("no such stream")
-        return
-      }
-      if st.serverUrl == svc.config.SelfUrl {
-        /* TODO: delegate to stream cleanup function? */
-        svc.redis.Del(streamKey(key))
-        ctx.resp.StringError("connection lost")
-        return
-      }
-      /* TODO: proxy request to serverUrl */
-      hi1.Printf("forward request to %s\n", st.serverUrl)
-      ctx.resp.StringError("forwarding is not implemented")
-      return
-    }
-    /* TODO: either filter subscription to contest, team channel,
-       or generate unguessable contest/team channel keys. */
+    if st == nil { ctx.resp.StringError("no such stream"); return }
     if len(req.Unsubscribe) > 0 {
       err = st.Unsubscribe(req.Unsubscribe...)
       if err != nil { ctx.resp.Error(err); return }
     }
-    if len(req.Subscribe) > 0 {
-      err = st.Subscribe(req.Subscribe...)
+    var allowed []string
+    filters := make(map[string]*Filter)
+    items := j.Array()
+    for _, sub := range req.Subscribe {
+      channel := sub.Channel
+      item := j.Object()
+      item.Prop("channel", j.String(channel))
+      err = svc.authorizeChannel(st, channel)
+      if err == nil && sub.Filter != nil {
+        err = sub.Filter.Validate(channel)
+      }
+      if err == ErrChannelDenied || err == ErrChannelUnknown || err == ErrFilterUnsupported {
+        item.Prop("ok", j.Boolean(false))
+        item.Prop("error", j.String(err.Error()))
+      } else if err != nil {
+        ctx.resp.Error(err); return
+      } else {
+        item.Prop("ok", j.Boolean(true))
+        allowed = append(allowed, channel)
+        /* Subscribing again without a filter clears the previous one. */
+        filters[channel] = sub.Filter
+      }
+      items.Item(item)
+    }
+    if len(allowed) > 0 {
+      err = st.SetFilters(filters)
+      if err != nil { ctx.resp.Error(err); return }
+      err = st.Subscribe(allowed...)
       if err != nil { ctx.resp.Error(err); return }
     }
-    ctx.resp.Result(j.Boolean(true))
+    result := j.Object()
+    result.Prop("subscribe", items)
+    ctx.resp.Result(result)
     /*
       // fmt.Printf("request is signed by %s\n", req.Author)
       team, err := svc.model.LoadTeam(teamId, model.NullFacet)
@@ -9,10 +9,10 @@ import (
   "math/rand"
   "sync"
   "time"
-  "github.com/go-redis/redis"
   "github.com/fatih/color"
   "tezos-contests.izibi.com/backend/config"
   "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/pubsub"
   "tezos-contests.izibi.com/backend/auth"
 )
 
@@ -22,13 +22,15 @@ const (
 var MaxStreamIdleDuration = 5 * time.Minute
 var RedisStreamKeyExpiry = 5 * time.Minute
 var RedisStreamKeyRefresh = 4 * time.Minute
+var ShutdownRetryMin = 500
+var ShutdownRetrySpread = 2000
 
 var hi1 = color.New(color.Bold, color.FgCyan)
 var hi2 = color.New(color.Bold, color.FgBlue)
 
 type Service struct {
   config *config.Config
-  redis *redis.Client
+  backend pubsub.Backend
   model *model.Model
   auth *auth.Service
   rng *rand.Rand
@@ -36,15 +38,19 @@ type Service struct {
   mutex sync.RWMutex
   idleStreams map[string]*stream
   streams map[string]*stream
+  shutdown chan struct{} /* closed when the server shuts down */
+  shutdownOnce sync.Once
+  stats *channelStats
+  webhookEvents chan *webhookEvent /* events to record deliveries for */
 }
 
-func NewService(cfg *config.Config, rc *redis.Client, model *model.Model, auth *auth.Service) (*Service, error) {
+func NewService(cfg *config.Config, backend pubsub.Backend, model *model.Model, auth *auth.Service) (*Service, error) {
   var err error
   rng, err := seededRng()
   if err != nil { return nil, err }
   return &Service{
     cfg,
-    rc,
+    backend,
     model,
     auth,
     rng,
@@ -52,6 +58,10 @@ func NewService(cfg *config.Config, rc *redis.Client, model *model.Model, auth *
     sync.RWMutex{},
     map[string]*stream{},
     map[string]*stream{},
+    make(chan struct{}),
+    sync.Once{},
+    newChannelStats(),
+    make(chan *webhookEvent, WebhookQueueSize),
   }, nil
 }
 
@@ -61,16 +71,48 @@ func (svc *Service) Run() {
      they reach their intended audience.
    */
   periodic := time.NewTicker(10 * time.Second)
+  control := svc.backend.Subscribe(controlChannel(svc.config.SelfUrl))
+  controlMessages := control.Channel()
   for {
     select {
       case _ = <-periodic.C:
         svc.periodicTask()
       case m := <-svc.channel:
         _ = svc.handleMessage(m)
+      case msg := <-controlMessages:
+        if msg == nil {
+          hi2.Printf("control channel closed\n")
+          controlMessages = nil
+          continue
+        }
+        err := svc.handleControl(msg.Payload)
+        if err != nil && verbose {
+          hi2.Printf("control message failed: %v\n", err)
+        }
     }
   }
 }
 
+/*
+  Closes the connected event streams, after telling their clients to
+  reconnect shortly (the reconnection delays are spread to avoid a burst of
+  connections on the remaining instances).  The streams' redis keys are
+  kept so that clients resume them on another instance.  The webhook
+  deliveries stop being polled.
+*/
+func (svc *Service) Shutdown() {
+  svc.shutdownOnce.Do(func () {
+    close(svc.shutdown)
+  })
+}
+
+/* Reconnection delay suggested to clients on shutdown, in milliseconds. */
+func (svc *Service) shutdownRetryDelay() int {
+  svc.mutex.Lock()
+  defer svc.mutex.Unlock()
+  return ShutdownRetryMin + svc.rng.Intn(ShutdownRetrySpread)
+}
+
 func (svc *Service) periodicTask() {
   /* Handle the periodic cleanup of idle event streams. A stream is considered
      idle if it has been disconnected for a period of time. */
@@ -88,31 +130,38 @@ func (svc *Service) periodicTask() {
   svc.mutex.Unlock()
   for _, st := range streams {
     _ = st.pubSub.Close()
-    _ = svc.redis.Del(streamKey(st.key)).Err()
+    /* Leave the redis keys alone if another instance took over. */
+    serverUrl, _ := svc.backend.Get(streamKey(st.key))
+    if serverUrl == svc.config.SelfUrl {
+      _ = svc.backend.Del(streamKey(st.key), streamSubscriptionsKey(st.key), streamOwnerKey(st.key),
+        streamFiltersKey(st.key))
+    }
   }
+  svc.stats.prune(now)
 }
 
 func (svc *Service) handleMessage(msg interface{}) error {
   var err error
   var key string
-  var payload string
+  var event *Event
   switch m := msg.(type) {
   case *contestMessage:
-    payload = m.payload
+    event = m.event
     key, err = svc.getContestChannel(m.contestId)
     if err != nil { return err }
   case *teamMessage:
-    payload = m.payload
+    event = m.event
     key, err = svc.getTeamChannel(m.teamId)
     if err != nil { return err }
   case *gameMessage:
-    payload = m.payload
+    event = m.event
     key, err = svc.getGameChannel(m.gameKey)
   default:
     return errors.New("unhandled message type")
   }
-  err = svc.redis.Publish(key, payload).Err()
+  err = svc.appendEvent(key, event)
   if err != nil { return err }
+  svc.enqueueWebhookEvent(&webhookEvent{msg, key, event})
   return nil
 }
 
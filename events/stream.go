@@ -4,35 +4,40 @@ package events
 import (
   "fmt"
   "encoding/base64"
-  "strconv"
+  "sync"
   "time"
   "github.com/go-errors/errors"
-  "github.com/go-redis/redis"
-  "github.com/fatih/color"
+  "tezos-contests.izibi.com/backend/pubsub"
 )
 
-var SuccessFmt = color.New(color.Bold, color.FgGreen)
-
 type stream struct {
   svc *Service
   key string
   idleSince time.Time
+  connectedAt time.Time
   serverUrl string
-  pubSub *redis.PubSub
-  lastId uint64 /* greatest id of events prepared */
-  recvId uint64 /* greatest id received by client, set on reconnect */
+  pubSub pubsub.Subscription
   userId int64
   teamId int64
   contestId int64
-  recent []*SSEvent
+  mutex sync.Mutex /* protects cursor, dirty, pending, filters and connectedAt */
+  cursor map[string]string /* channel -> id of the last entry delivered */
+  dirty map[string]bool /* channels that may have undelivered entries */
+  pending []*SSEvent
+  filters map[string]*Filter /* channel -> filter */
+  legacy bool /* send events in the untyped string format */
   closeChan chan bool
+  released bool /* set when another instance took over the stream */
 }
 
 type SSEvent struct {
-  Id uint64
+  Id string
   Event string
   Data string
-  Timestamp time.Time
+  /* Origin of an event read from history, for filtering. */
+  channel string
+  typ string
+  body string
 }
 
 func (svc *Service) newStream() (*stream, error) {
@@ -45,16 +50,15 @@ func (svc *Service) newStream() (*stream, error) {
     key: key,
     idleSince: time.Now(),
     serverUrl: svc.config.SelfUrl,
-    pubSub: svc.redis.Subscribe("system"),
-    lastId: 1,
-    recvId: 0,
+    pubSub: svc.backend.Subscribe("system"),
     userId: 0,
     teamId: 0,
     contestId: 0,
-    recent: nil,
+    cursor: make(map[string]string),
+    dirty: make(map[string]bool),
     closeChan: make(chan bool),
   }
-  err = svc.redis.Set(streamKey(key), st.serverUrl, 5 * time.Minute).Err()
+  err = svc.backend.Set(streamKey(key), st.serverUrl, 5 * time.Minute)
   if err != nil { return nil, errors.Wrap(err, 0) }
   {
     svc.mutex.Lock()
@@ -64,7 +68,7 @@ func (svc *Service) newStream() (*stream, error) {
   return st, nil
 }
 
-func (svc *Service) connectStream(key string, recvId string) (*stream, bool, error) {
+func (svc *Service) connectStream(key string, lastEventId string) (*stream, bool, error) {
   var err error
   var st *stream
   var idleFound, activeFound bool
@@ -84,49 +88,89 @@ func (svc *Service) connectStream(key string, recvId string) (*stream, bool, err
     /* Found but already connected. */
     return st, false, nil
   }
-  if !idleFound {
+  if idleFound {
+    /* The client may have been connected to another instance meanwhile. */
+    err = svc.claimStream(key)
+    if err != nil {
+      /* Put the stream back, to be pruned once it has been idle long
+         enough. */
+      svc.mutex.Lock()
+      if svc.streams[key] == st {
+        delete(svc.streams, key)
+        svc.idleStreams[key] = st
+      }
+      svc.mutex.Unlock()
+      return nil, false, err
+    }
+  } else {
     st, err = svc.resumeStream(key)
     if err != nil { return nil, false, err }
+    if st == nil { return nil, false, nil }
   }
-  st.recvId, _ = strconv.ParseUint(recvId, 10, 64)
+  st.resume(lastEventId)
+  st.mutex.Lock()
+  st.connectedAt = time.Now()
+  st.mutex.Unlock()
   if verbose {
     hi1.Printf("+ %s\n", key)
   }
   return st, true, nil
 }
 
+/* Atomically marks this instance as the stream controller in redis, and
+   tells the previous controller to release its copy of the stream.
+   Fails if the stream does not exist (or has expired). */
+func (svc *Service) claimStream(key string) error {
+  sKey := streamKey(key)
+  serverUrl, err := svc.backend.GetSet(sKey, svc.config.SelfUrl)
+  if err == pubsub.ErrNil {
+    svc.backend.Del(sKey)
+    return errors.New("no such stream")
+  }
+  if err != nil { return errors.Wrap(err, 0) }
+  err = svc.backend.Expire(sKey, RedisStreamKeyExpiry)
+  if err != nil { return errors.Wrap(err, 0) }
+  if serverUrl != svc.config.SelfUrl {
+    fmt.Printf("stream %s transfered from %s\n", key, serverUrl)
+    err = svc.sendControl(serverUrl, &controlMessage{Op: "release", Key: key})
+    if err != nil { return err }
+  }
+  return nil
+}
+
 func (svc *Service) resumeStream(key string) (*stream, error) {
   var err error
-  /* Atomically mark ourselves as the stream controller in redis. */
-  var serverUrl string
   sKey := streamKey(key)
-  serverUrl, err = svc.redis.GetSet(sKey, svc.config.SelfUrl).Result()
+  _, err = svc.backend.Get(sKey)
+  if err == pubsub.ErrNil { return nil, nil }
   if err != nil { return nil, errors.Wrap(err, 0) }
-  if serverUrl != svc.config.SelfUrl {
-    // TODO: tell serverUrl we are now controlling the stream
-    fmt.Printf("stream %s transfered from %s\n", key)
-  } else {
-    fmt.Printf("stream %s reconnected\n", key)
-  }
+  err = svc.claimStream(key)
+  if err != nil { return nil, err }
   /* Reload subscriptions */
   var subs []string
   ssKey := streamSubscriptionsKey(key)
-  subs, err = svc.redis.SMembers(ssKey).Result()
+  subs, err = svc.backend.SMembers(ssKey)
   if err != nil { return nil, errors.Wrap(err, 0) }
   /* Rebuild the stream object. */
-  var lastId uint64
   st := &stream{
     svc: svc,
     key: key,
     idleSince: time.Now(),
     serverUrl: svc.config.SelfUrl,
-    pubSub: svc.redis.Subscribe(append(subs, "system")...),
-    lastId: lastId,
-    recvId: 0,
+    pubSub: svc.backend.Subscribe("system"),
     userId: 0,
     teamId: 0,
     contestId: 0,
-    recent: nil,
+    cursor: make(map[string]string),
+    dirty: make(map[string]bool),
+    closeChan: make(chan bool),
+  }
+  err = svc.loadStreamOwner(st)
+  if err == nil { err = st.reloadFilters() }
+  if err == nil && len(subs) != 0 { err = st.subscribeLocal(subs...) }
+  if err != nil {
+    _ = st.pubSub.Close()
+    return nil, err
   }
   svc.mutex.Lock()
   svc.streams[key] = st
@@ -134,12 +178,61 @@ func (svc *Service) resumeStream(key string) (*stream, error) {
   return st, nil
 }
 
+/*
+  Finds a stream for handling a request.  If the stream is controlled by
+  another instance, the returned stream has a nil pubSub and requests made
+  on it are relayed to its controller.
+  Returns nil if the stream does not exist.
+*/
+func (svc *Service) lookupStream(key string) (*stream, error) {
+  var err error
+  st, err := svc.getStream(key)
+  if err == nil { return st, nil }
+  var serverUrl string
+  serverUrl, err = svc.backend.Get(streamKey(key))
+  if err == pubsub.ErrNil { return nil, nil }
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  if serverUrl == svc.config.SelfUrl {
+    /* The stream was lost, for example on restart. */
+    svc.backend.Del(streamKey(key), streamSubscriptionsKey(key), streamOwnerKey(key),
+      streamFiltersKey(key))
+    return nil, nil
+  }
+  st = &stream{
+    svc: svc,
+    key: key,
+    serverUrl: serverUrl,
+  }
+  err = svc.loadStreamOwner(st)
+  if err != nil { return nil, err }
+  return st, nil
+}
+
+/* Disconnects the client of a stream, wherever it is controlled, and
+   deletes the stream. */
+func (svc *Service) dropStream(st *stream) error {
+  var err error
+  if st.pubSub == nil {
+    err = svc.sendControl(st.serverUrl, &controlMessage{Op: "release", Key: st.key})
+    if err != nil { return err }
+  } else {
+    svc.releaseStream(st.key)
+  }
+  err = svc.backend.Del(streamKey(st.key), streamSubscriptionsKey(st.key),
+    streamOwnerKey(st.key), streamFiltersKey(st.key))
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
 func (svc *Service) disconnectStream(st *stream) error {
   if verbose {
     hi1.Printf("- %s\n", st.key)
   }
   svc.mutex.Lock()
-  svc.idleStreams[st.key] = st
+  if !st.released {
+    st.idleSince = time.Now()
+    svc.idleStreams[st.key] = st
+  }
   delete(svc.streams, st.key)
   svc.mutex.Unlock()
   /* TODO: save the client state in redis? */
@@ -148,9 +241,13 @@ func (svc *Service) disconnectStream(st *stream) error {
 
 func (svc *Service) refreshStream(st *stream) error {
   var err error
-  err = svc.redis.Expire(streamKey(st.key), RedisStreamKeyExpiry).Err()
+  err = svc.backend.Expire(streamKey(st.key), RedisStreamKeyExpiry)
+  if err != nil { return errors.Wrap(err, 0) }
+  err = svc.backend.Expire(streamSubscriptionsKey(st.key), RedisStreamKeyExpiry)
+  if err != nil { return errors.Wrap(err, 0) }
+  err = svc.backend.Expire(streamOwnerKey(st.key), RedisStreamKeyExpiry)
   if err != nil { return errors.Wrap(err, 0) }
-  err = svc.redis.Expire(streamSubscriptionsKey(st.key), RedisStreamKeyExpiry).Err()
+  err = svc.backend.Expire(streamFiltersKey(st.key), RedisStreamKeyExpiry)
   if err != nil { return errors.Wrap(err, 0) }
   return nil
 }
@@ -172,59 +269,23 @@ func (svc *Service) getStream(key string) (*stream, error) {
   return st, nil
 }
 
-/* Call to build an event that will be sent to the client.
-   Not synchronized, call only from inside the http stream writer.
- */
-func (st *stream) Push(msg string) *SSEvent {
-
-  st.lastId++
-  now := time.Now()
-  event := &SSEvent{
-    Id: st.lastId,
-    Event: "message",
-    Data: msg,
-    Timestamp: now,
-  }
-
-  /* Keep up to 1 minute of recent events. */
-  st.recent = append(st.recent, event)
-  cutoff := now.Add(-60 * time.Second)
-  var i int
-  var ev *SSEvent
-  for i, ev = range st.recent {
-    if ev.Timestamp.After(cutoff) {
-      break
-    }
-  }
-  st.recent = st.recent[i:]
-
-  return event
-}
-
-func (st *stream) Resend() *SSEvent {
-  if st.recvId != 0 {
-    var ev *SSEvent
-    for _, ev = range st.recent {
-      if ev.Id > st.recvId {
-        /* Do not truncate the recent events list in case the client
-           disconnects (again). */
-        SuccessFmt.Printf("Resending event %d > %d\n", ev.Id, st.recvId)
-        st.recvId = ev.Id
-        return ev
-      }
-    }
-    st.recvId = 0
-  }
-  return nil
-}
-
 func (st *stream) Subscribe(channels ...string) error {
   var err error
   skey := streamSubscriptionsKey(st.key)
-  err = st.svc.redis.SAdd(skey, stringsToAnys(channels)...).Err()
+  err = st.svc.backend.SAdd(skey, channels...)
   if err != nil { return errors.Wrap(err, 0) }
-  err = st.svc.redis.Expire(skey, RedisStreamKeyExpiry).Err()
+  err = st.svc.backend.Expire(skey, RedisStreamKeyExpiry)
   if err != nil { return errors.Wrap(err, 0) }
+  if st.pubSub == nil {
+    return st.svc.sendControl(st.serverUrl,
+      &controlMessage{Op: "subscribe", Key: st.key, Channels: channels})
+  }
+  return st.subscribeLocal(channels...)
+}
+
+func (st *stream) subscribeLocal(channels ...string) error {
+  err := st.watch(channels...)
+  if err != nil { return err }
   err = st.pubSub.Subscribe(channels...)
   if err != nil { return errors.Wrap(err, 0) }
   return nil
@@ -233,46 +294,78 @@ func (st *stream) Subscribe(channels ...string) error {
 func (st *stream) Unsubscribe(channels ...string) error {
   var err error
   skey := streamSubscriptionsKey(st.key)
-  err = st.svc.redis.SRem(skey, stringsToAnys(channels)...).Err()
+  err = st.svc.backend.SRem(skey, channels...)
   if err != nil { return errors.Wrap(err, 0) }
-  err = st.svc.redis.Expire(skey, RedisStreamKeyExpiry).Err()
+  err = st.svc.backend.Expire(skey, RedisStreamKeyExpiry)
   if err != nil { return errors.Wrap(err, 0) }
-  err = st.pubSub.Unsubscribe(channels...)
+  err = st.svc.dropFilters(st.key, channels)
+  if err != nil { return err }
+  if st.pubSub == nil {
+    return st.svc.sendControl(st.serverUrl,
+      &controlMessage{Op: "unsubscribe", Key: st.key, Channels: channels})
+  }
+  return st.unsubscribeLocal(channels...)
+}
+
+func (st *stream) unsubscribeLocal(channels ...string) error {
+  st.unwatch(channels...)
+  err := st.pubSub.Unsubscribe(channels...)
   if err != nil { return errors.Wrap(err, 0) }
   return nil
 }
 
+/* Asks the client connection (if any) to terminate. */
+func (st *stream) Close() error {
+  if st.pubSub == nil {
+    return st.svc.sendControl(st.serverUrl, &controlMessage{Op: "close", Key: st.key})
+  }
+  select {
+  case st.closeChan <- true:
+  default:
+  }
+  return nil
+}
+
 func (st *stream) SetUserId(userId int64) error {
   st.userId = userId
-  return nil
+  return st.saveOwner()
 }
 
 func (st *stream) SetTeamId(teamId int64) error {
   var err error
+  var ch string
   if st.teamId != 0 {
-    var ch string
-    ch, err := st.svc.getTeamChannel(st.teamId)
-    if err != nil {
-      _ = st.pubSub.Unsubscribe(ch)
+    ch, err = st.svc.getTeamChannel(st.teamId)
+    if err == nil {
+      _ = st.Unsubscribe(ch)
     }
   }
   st.teamId = teamId
   if st.teamId != 0 {
-    var ch string
     ch, err = st.svc.getTeamChannel(st.teamId)
     if err != nil { return err }
-    err = st.pubSub.Subscribe(ch)
-    if err != nil { return errors.Wrap(err, 0) }
+    err = st.Subscribe(ch)
+    if err != nil { return err }
   }
+  return st.saveOwner()
+}
+
+/* The stream's owner is stored in redis so that any instance can check
+   the subscriptions requested for the stream. */
+func (st *stream) saveOwner() error {
+  owner := fmt.Sprintf("%d %d", st.userId, st.teamId)
+  err := st.svc.backend.Set(streamOwnerKey(st.key), owner, RedisStreamKeyExpiry)
+  if err != nil { return errors.Wrap(err, 0) }
   return nil
 }
 
-func stringsToAnys(strs []string) []interface{} {
-  var anys = make([]interface{}, len(strs))
-  for i, s := range strs {
-    anys[i] = s
-  }
-  return anys
+func (svc *Service) loadStreamOwner(st *stream) error {
+  owner, err := svc.backend.Get(streamOwnerKey(st.key))
+  if err == pubsub.ErrNil { return nil }
+  if err != nil { return errors.Wrap(err, 0) }
+  _, err = fmt.Sscanf(owner, "%d %d", &st.userId, &st.teamId)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
 }
 
 func streamKey(key string) string {
@@ -282,3 +375,7 @@ func streamKey(key string) string {
 func streamSubscriptionsKey(key string) string {
   return fmt.Sprintf("stream:%s:subs", key)
 }
+
+func streamOwnerKey(key string) string {
+  return fmt.Sprintf("stream:%s:owner", key)
+}
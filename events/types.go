@@ -0,0 +1,204 @@
+/*
+  Typed events.
+
+  An event has a type (also used as the SSE event name), a JSON body whose
+  layout is given by EventVersion, and a timestamp.  Each event also keeps
+  the free-form string that was sent to clients before events were typed;
+  clients that connect with ?format=legacy still receive these strings.
+*/
+
+package events
+
+import (
+  "fmt"
+  "strconv"
+  "time"
+  j "tezos-contests.izibi.com/backend/jase"
+)
+
+/* Incremented whenever the body of an event type changes incompatibly. */
+const EventVersion = 1
+
+const (
+  EventBlock = "block"
+  EventRoundClosed = "round_closed"
+  EventPing = "ping"
+  EventChainCreated = "chain_created"
+  EventChainDeleted = "chain_deleted"
+  EventChainRestarted = "chain_restarted"
+  EventProposalCreated = "proposal_created"
+  EventProposalAccepted = "proposal_accepted"
+  EventProposalRejected = "proposal_rejected"
+  EventTeamUpdated = "team_updated"
+  EventTeamMessage = "team_message"
+  EventGameAnnouncement = "game_announcement"
+)
+
+type Event struct {
+  Type string
+  Version int
+  Time time.Time
+  Body j.Value
+  Legacy string
+  TeamId int64 /* team concerned by a contest event, for webhooks */
+}
+
+func newEvent(typ string, body j.IObject, legacy string) *Event {
+  return &Event{
+    Type: typ,
+    Version: EventVersion,
+    Time: time.Now(),
+    Body: body,
+    Legacy: legacy,
+  }
+}
+
+/* Designates the team whose webhooks receive a contest event. */
+func (ev *Event) ForTeam(teamId int64) *Event {
+  ev.TeamId = teamId
+  return ev
+}
+
+/*
+  Game events carry the round they relate to and the ranks of the players
+  involved, which stream filters match against.
+*/
+
+/* A new block was added to a game, with the commands of the given round. */
+func NewBlockEvent(gameKey string, hash string, round uint64, ranks []uint32) *Event {
+  body := j.Object()
+  body.Prop("gameKey", j.String(gameKey))
+  body.Prop("hash", j.String(hash))
+  body.Prop("round", j.Uint64(round))
+  body.Prop("ranks", ranksArray(ranks))
+  return newEvent(EventBlock, body, fmt.Sprintf("block %s", hash))
+}
+
+/* The commands for a game round were collected, the next block is being
+   computed. */
+func RoundClosedEvent(gameKey string, round uint64, ranks []uint32) *Event {
+  body := j.Object()
+  body.Prop("gameKey", j.String(gameKey))
+  body.Prop("round", j.Uint64(round))
+  body.Prop("ranks", ranksArray(ranks))
+  return newEvent(EventRoundClosed, body, fmt.Sprintf("round %d closed", round))
+}
+
+/* Bots playing a game must answer with the given key. */
+func PingEvent(gameKey string, key string, ranks []uint32) *Event {
+  body := j.Object()
+  body.Prop("gameKey", j.String(gameKey))
+  body.Prop("key", j.String(key))
+  body.Prop("ranks", ranksArray(ranks))
+  return newEvent(EventPing, body, fmt.Sprintf("ping %s", key))
+}
+
+func ranksArray(ranks []uint32) j.IArray {
+  res := j.Array()
+  for _, rank := range ranks {
+    res.Item(j.Uint32(rank))
+  }
+  return res
+}
+
+/* Chain events take exported (view) chain ids. */
+func ChainCreatedEvent(chainId string) *Event {
+  return chainEvent(EventChainCreated, chainId, "created")
+}
+
+func ChainDeletedEvent(chainId string) *Event {
+  return chainEvent(EventChainDeleted, chainId, "deleted")
+}
+
+func ChainRestartedEvent(chainId string) *Event {
+  return chainEvent(EventChainRestarted, chainId, "restarted")
+}
+
+func chainEvent(typ string, chainId string, verb string) *Event {
+  body := j.Object()
+  body.Prop("chainId", j.String(chainId))
+  return newEvent(typ, body, fmt.Sprintf("chain %s %s", chainId, verb))
+}
+
+func ProposalCreatedEvent(proposalId string, targetChainId string) *Event {
+  return proposalEvent(EventProposalCreated, proposalId, targetChainId, "created")
+}
+
+func ProposalAcceptedEvent(proposalId string, targetChainId string) *Event {
+  return proposalEvent(EventProposalAccepted, proposalId, targetChainId, "accepted")
+}
+
+func ProposalRejectedEvent(proposalId string, targetChainId string) *Event {
+  return proposalEvent(EventProposalRejected, proposalId, targetChainId, "rejected")
+}
+
+func proposalEvent(typ string, proposalId string, targetChainId string, verb string) *Event {
+  body := j.Object()
+  body.Prop("proposalId", j.String(proposalId))
+  body.Prop("targetChainId", j.String(targetChainId))
+  return newEvent(typ, body, fmt.Sprintf("proposal %s %s", proposalId, verb))
+}
+
+func TeamUpdatedEvent(teamId string) *Event {
+  body := j.Object()
+  body.Prop("teamId", j.String(teamId))
+  return newEvent(EventTeamUpdated, body, fmt.Sprintf("team %s updated", teamId))
+}
+
+/* A message posted to a team's channel by one of its members (userId is
+   the member's exported id) or, if userId is empty, by one of its bots.
+   The kind and data are chosen by the team's tooling. */
+func TeamMessageEvent(teamId string, userId string, kind string, data []byte) *Event {
+  body := j.Object()
+  body.Prop("teamId", j.String(teamId))
+  if userId != "" {
+    body.Prop("userId", j.String(userId))
+  }
+  body.Prop("kind", j.String(kind))
+  body.Prop("data", j.Raw(data))
+  return newEvent(EventTeamMessage, body, fmt.Sprintf("team message %s", kind))
+}
+
+/* An announcement by a game's owner to the game's players. */
+func GameAnnouncementEvent(gameKey string, kind string, data []byte) *Event {
+  body := j.Object()
+  body.Prop("gameKey", j.String(gameKey))
+  body.Prop("kind", j.String(kind))
+  body.Prop("data", j.Raw(data))
+  return newEvent(EventGameAnnouncement, body, fmt.Sprintf("game announcement %s", kind))
+}
+
+/* Fields of the redis stream entry holding the event. */
+func (ev *Event) entryValues() (map[string]string, error) {
+  body, err := j.ToString(ev.Body)
+  if err != nil { return nil, err }
+  return map[string]string{
+    "type": ev.Type,
+    "version": strconv.Itoa(ev.Version),
+    "time": ev.Time.UTC().Format(time.RFC3339Nano),
+    "body": body,
+    "payload": ev.Legacy,
+  }, nil
+}
+
+/* Builds the SSE event for a history entry, in the typed or legacy format. */
+func entryToSSEvent(channel string, values map[string]string, legacy bool) (*SSEvent, error) {
+  typ := values["type"]
+  payload := values["payload"]
+  if legacy || typ == "" {
+    return &SSEvent{Event: "message", Data: encodeMessage(channel, payload),
+      channel: channel, typ: typ, body: values["body"]}, nil
+  }
+  version := values["version"]
+  timestamp := values["time"]
+  body := values["body"]
+  obj := j.Object()
+  obj.Prop("channel", j.String(channel))
+  obj.Prop("type", j.String(typ))
+  obj.Prop("version", j.Raw([]byte(version)))
+  obj.Prop("time", j.String(timestamp))
+  obj.Prop("body", j.Raw([]byte(body)))
+  data, err := j.ToString(obj)
+  if err != nil { return nil, err }
+  return &SSEvent{Event: typ, Data: data, channel: channel, typ: typ, body: body}, nil
+}
@@ -0,0 +1,181 @@
+/*
+  Outgoing webhooks.
+
+  Events concerning a team are also delivered to the webhooks registered by
+  the team: a delivery is recorded for each matching webhook, and a worker
+  posts pending deliveries, retrying failed attempts with an exponential
+  backoff.  Deliveries are signed with the webhook's secret.
+  Deliveries are recorded by a go routine reading a buffered channel, so
+  that the database queries involved do not hold up the messages loop;
+  when the channel is full, events are not delivered to webhooks.
+  Deliveries of a webhook that was deactivated are marked failed.
+*/
+
+package events
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "strconv"
+  "time"
+  "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/signing"
+  "tezos-contests.izibi.com/backend/utils"
+)
+
+var WebhookPollInterval = 5 * time.Second
+var WebhookTimeout = 10 * time.Second
+var WebhookRetryDelay = 30 * time.Second
+var WebhookMaxRetryDelay = 1 * time.Hour
+var WebhookBatchSize = 20
+var WebhookQueueSize = 1000
+
+type webhookEvent struct {
+  msg interface{} /* contest, team or game message */
+  channel string
+  event *Event
+}
+
+func (svc *Service) enqueueWebhookEvent(we *webhookEvent) {
+  select {
+  case svc.webhookEvents <- we:
+  default:
+    hi2.Printf("webhooks: queue is full, dropped %s event\n", we.event.Type)
+  }
+}
+
+/* Records the deliveries of queued events. */
+func (svc *Service) runWebhookQueue() {
+  for {
+    select {
+    case we := <-svc.webhookEvents:
+      teamIds, err := svc.webhookTeams(we.msg)
+      if err == nil {
+        err = svc.queueWebhooks(teamIds, we.channel, we.event)
+      }
+      if err != nil {
+        hi2.Printf("webhooks: %v\n", err)
+      }
+    case <-svc.shutdown:
+      return
+    }
+  }
+}
+
+/* Records the deliveries of an event to the webhooks of the given teams. */
+func (svc *Service) queueWebhooks(teamIds []int64, channel string, event *Event) error {
+  if len(teamIds) == 0 { return nil }
+  hooks, err := svc.model.LoadActiveWebhooks(teamIds)
+  if err != nil { return err }
+  if len(hooks) == 0 { return nil }
+  values, err := event.entryValues()
+  if err != nil { return err }
+  sse, err := entryToSSEvent(channel, values, false)
+  if err != nil { return err }
+  for i := range hooks {
+    if !hooks[i].Accepts(event.Type) { continue }
+    err = svc.model.CreateWebhookDelivery(hooks[i].Id, event.Type, sse.Data)
+    if err != nil { return err }
+  }
+  return nil
+}
+
+/* Returns the teams whose webhooks receive an event posted on a channel. */
+func (svc *Service) webhookTeams(msg interface{}) ([]int64, error) {
+  switch m := msg.(type) {
+  case *contestMessage:
+    if m.event.TeamId != 0 {
+      return []int64{m.event.TeamId}, nil
+    }
+  case *teamMessage:
+    return []int64{m.teamId}, nil
+  case *gameMessage:
+    return svc.model.LoadGameTeamIds(m.gameKey)
+  }
+  return nil, nil
+}
+
+/* This method is intended to be invoked as a go routine. */
+func (svc *Service) RunWebhooks() {
+  go svc.runWebhookQueue()
+  client := utils.PublicHttpClient(WebhookTimeout)
+  ticker := time.NewTicker(WebhookPollInterval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ticker.C:
+    case <-svc.shutdown:
+      return
+    }
+    /* The lease prevents other instances from sending the same deliveries
+       while the batch is being processed. */
+    lease := time.Duration(WebhookBatchSize) * WebhookTimeout
+    deliveries, err := svc.model.ClaimDueWebhookDeliveries(WebhookBatchSize, lease)
+    if err != nil {
+      hi2.Printf("webhooks: %v\n", err)
+      continue
+    }
+    for i := range deliveries {
+      err = svc.deliverWebhook(client, &deliveries[i])
+      if err != nil {
+        hi2.Printf("webhooks: %v\n", err)
+      }
+    }
+  }
+}
+
+func (svc *Service) deliverWebhook(client *http.Client, delivery *model.WebhookDelivery) error {
+  hook, err := svc.model.LoadTeamWebhook(delivery.Webhook_id)
+  if err != nil { return err }
+  if !hook.Is_active {
+    return svc.model.CancelWebhookDelivery(delivery, "webhook is inactive")
+  }
+  statusCode, attemptErr := postWebhook(client, hook, delivery)
+  var errMsg string
+  if attemptErr != nil {
+    errMsg = attemptErr.Error()
+    if statusCode == 0 {
+      /* Transport errors are not recorded, as they would reveal details
+         of the network the backend runs in. */
+      errMsg = "request failed"
+    }
+  }
+  return svc.model.RecordWebhookAttempt(delivery, statusCode, errMsg,
+    webhookRetryDelay(delivery.Nb_attempts + 1))
+}
+
+func postWebhook(client *http.Client, hook *model.TeamWebhook, delivery *model.WebhookDelivery) (int, error) {
+  body := []byte(delivery.Payload)
+  timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+  req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(body))
+  if err != nil { return 0, err }
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("X-Webhook-Id", strconv.FormatInt(hook.Id, 10))
+  req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.Id, 10))
+  req.Header.Set("X-Webhook-Event", delivery.Event_type)
+  req.Header.Set("X-Webhook-Timestamp", timestamp)
+  req.Header.Set("X-Webhook-Signature", signing.WebhookSignature(hook.Secret, timestamp, body))
+  resp, err := client.Do(req)
+  if err != nil { return 0, err }
+  defer resp.Body.Close()
+  _, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64 * 1024))
+  if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+    return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+  }
+  return resp.StatusCode, nil
+}
+
+/* Delay before the next attempt, after nbAttempts failed attempts. */
+func webhookRetryDelay(nbAttempts int) time.Duration {
+  delay := WebhookRetryDelay
+  for i := 1; i < nbAttempts && delay < WebhookMaxRetryDelay; i++ {
+    delay *= 2
+  }
+  if delay > WebhookMaxRetryDelay {
+    delay = WebhookMaxRetryDelay
+  }
+  return delay
+}
@@ -4,7 +4,6 @@ package jase
 import (
   "bytes"
   "io"
-  "io/ioutil"
 )
 
 type Value interface {
@@ -22,10 +21,8 @@ type IArray interface {
 }
 
 func ToBytes(v Value) ([]byte, error) {
-  n, _ := v.WriteTo(ioutil.Discard)
-  b := bytes.NewBuffer(make([]byte, n))
-  b.Reset()
-  _, err := v.WriteTo(b)
+  var b bytes.Buffer
+  _, err := v.WriteTo(&b)
   if err != nil { return []byte{}, err }
   return b.Bytes(), nil
 }
@@ -36,6 +33,8 @@ func ToString(v Value) (string, error) {
   return string(bs), nil
 }
 
+/* The output of ToPrettyBytes is hashed (block and legacy signatures), so
+   it keeps going through PrettyBytes rather than a pretty Encoder. */
 func ToPrettyBytes(v Value) ([]byte, error) {
   var err error
   var bs []byte
@@ -0,0 +1,229 @@
+package jase
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "io"
+  "math"
+  "math/big"
+  "sort"
+  "strconv"
+  "unicode/utf16"
+  "unicode/utf8"
+)
+
+/*
+  JCS re-encodes a JSON document following RFC 8785 (JSON Canonicalization
+  Scheme):
+    - no whitespace;
+    - object properties sorted by the UTF-16 code units of their names;
+    - strings written as UTF-8, escaping only '"', '\', and control
+      characters (as \b, \t, \n, \f, \r or \u00xx);
+    - numbers parsed as IEEE 754 doubles and written as ECMAScript's
+      Number.prototype.toString does (so integers above 2^53 lose
+      precision, as in any I-JSON implementation).
+  Documents with duplicate property names, invalid UTF-8 or lone surrogates
+  in \u escapes are rejected.
+*/
+func JCS(b []byte) ([]byte, error) {
+  if !utf8.Valid(b) { return nil, errors.New("JSON document is not valid UTF-8") }
+  /* encoding/json would replace lone surrogates with U+FFFD. */
+  if err := checkSurrogates(b); err != nil { return nil, err }
+  dec := json.NewDecoder(bytes.NewReader(b))
+  dec.UseNumber()
+  val, err := jcsValue(dec)
+  if err != nil { return nil, err }
+  if _, err := dec.Token(); err != io.EOF {
+    return nil, errors.New("trailing data after JSON value")
+  }
+  return ToBytes(val)
+}
+
+/* Encodes a jase value in the RFC 8785 canonical form. */
+func ToJCSBytes(v Value) ([]byte, error) {
+  bs, err := ToBytes(v)
+  if err != nil { return nil, err }
+  return JCS(bs)
+}
+
+func jcsValue(dec *json.Decoder) (Value, error) {
+  tok, err := dec.Token()
+  if err != nil { return nil, err }
+  switch t := tok.(type) {
+  case json.Delim:
+    switch t {
+    case '{':
+      return jcsObject(dec)
+    case '[':
+      return jcsArray(dec)
+    }
+    return nil, errors.New("unexpected delimiter in JSON document")
+  case string:
+    return jcsString(t), nil
+  case json.Number:
+    return jcsNumber(t)
+  case bool:
+    return Boolean(t), nil
+  case nil:
+    return Null, nil
+  }
+  return nil, errors.New("unsupported value in JSON document")
+}
+
+type jcsMember struct {
+  key string
+  sortKey []uint16
+  value Value
+}
+
+func jcsObject(dec *json.Decoder) (Value, error) {
+  var members []jcsMember
+  seen := make(map[string]bool)
+  for dec.More() {
+    tok, err := dec.Token()
+    if err != nil { return nil, err }
+    key, ok := tok.(string)
+    if !ok { return nil, errors.New("expected property name") }
+    if seen[key] { return nil, errors.New("duplicate property " + strconv.Quote(key)) }
+    seen[key] = true
+    val, err := jcsValue(dec)
+    if err != nil { return nil, err }
+    members = append(members, jcsMember{key, utf16.Encode([]rune(key)), val})
+  }
+  if _, err := dec.Token(); err != nil { return nil, err } /* '}' */
+  sort.Slice(members, func (i, j int) bool {
+    a, b := members[i].sortKey, members[j].sortKey
+    for k := 0; k < len(a) && k < len(b); k++ {
+      if a[k] != b[k] { return a[k] < b[k] }
+    }
+    return len(a) < len(b)
+  })
+  return jcsObjectValue(members), nil
+}
+
+func jcsArray(dec *json.Decoder) (Value, error) {
+  arr := Array()
+  for dec.More() {
+    val, err := jcsValue(dec)
+    if err != nil { return nil, err }
+    arr.Item(val)
+  }
+  if _, err := dec.Token(); err != nil { return nil, err } /* ']' */
+  return arr, nil
+}
+
+/* An object whose property names are written with JCS string escaping. */
+type jcsObjectValue []jcsMember
+
+func (o jcsObjectValue) WriteTo(w io.Writer) (int64, error) {
+  var m int64
+  if n, err := w.Write([]byte("{")); err != nil { return m, err } else { m += int64(n) }
+  for i, member := range o {
+    if i != 0 {
+      if n, err := w.Write([]byte(",")); err != nil { return m, err } else { m += int64(n) }
+    }
+    if n, err := jcsString(member.key).WriteTo(w); err != nil { return m, err } else { m += n }
+    if n, err := w.Write([]byte(":")); err != nil { return m, err } else { m += int64(n) }
+    if n, err := member.value.WriteTo(w); err != nil { return m, err } else { m += n }
+  }
+  if n, err := w.Write([]byte("}")); err != nil { return m, err } else { m += int64(n) }
+  return m, nil
+}
+
+func jcsString(s string) Value {
+  var e bytes.Buffer
+  e.WriteByte('"')
+  for i := 0; i < len(s); i++ {
+    b := s[i]
+    switch {
+    case b == '"' || b == '\\':
+      e.WriteByte('\\')
+      e.WriteByte(b)
+    case b == '\b':
+      e.WriteString(`\b`)
+    case b == '\t':
+      e.WriteString(`\t`)
+    case b == '\n':
+      e.WriteString(`\n`)
+    case b == '\f':
+      e.WriteString(`\f`)
+    case b == '\r':
+      e.WriteString(`\r`)
+    case b < 0x20:
+      e.WriteString(`\u00`)
+      e.WriteByte(hex[b>>4])
+      e.WriteByte(hex[b&0xF])
+    default:
+      e.WriteByte(b)
+    }
+  }
+  e.WriteByte('"')
+  return Raw(e.Bytes())
+}
+
+func jcsNumber(n json.Number) (Value, error) {
+  f, err := strconv.ParseFloat(string(n), 64)
+  if err != nil || math.IsInf(f, 0) {
+    return nil, errors.New("number out of range: " + string(n))
+  }
+  if f == 0 { return Raw([]byte("0")), nil } /* also -0 */
+  return Float64(f), nil
+}
+
+/* Checks that the integers of a JSON document (numbers written without a
+   fraction or an exponent) are exactly representable as IEEE 754 doubles,
+   so that JCS does not round them. */
+func CheckExactIntegers(b []byte) error {
+  dec := json.NewDecoder(bytes.NewReader(b))
+  dec.UseNumber()
+  for {
+    tok, err := dec.Token()
+    if err == io.EOF { return nil }
+    if err != nil { return err }
+    n, ok := tok.(json.Number)
+    if !ok || bytes.ContainsAny([]byte(n), ".eE") { continue }
+    i, ok := new(big.Int).SetString(string(n), 10)
+    if !ok { return errors.New("bad integer " + string(n)) }
+    f, _ := strconv.ParseFloat(string(n), 64)
+    if math.IsInf(f, 0) { return errors.New("integer out of range: " + string(n)) }
+    if fi, _ := big.NewFloat(f).Int(nil); fi.Cmp(i) != 0 {
+      return errors.New("integer is not exactly representable as a double: " + string(n))
+    }
+  }
+}
+
+/* Checks that the \u escapes of UTF-16 surrogates in the strings of a
+   document come in high-low pairs. */
+func checkSurrogates(b []byte) error {
+  inString := false
+  for i := 0; i < len(b); i++ {
+    switch {
+    case b[i] == '"':
+      inString = !inString
+    case inString && b[i] == '\\' && i + 1 < len(b):
+      i++
+      if b[i] != 'u' { continue }
+      r1 := hexRune(b, i + 1)
+      if !utf16.IsSurrogate(r1) { continue }
+      i += 4
+      if r1 < 0xdc00 && i + 6 < len(b) && b[i+1] == '\\' && b[i+2] == 'u' {
+        r2 := hexRune(b, i + 3)
+        if utf16.DecodeRune(r1, r2) != utf8.RuneError {
+          i += 6
+          continue
+        }
+      }
+      return errors.New("lone surrogate in JSON string")
+    }
+  }
+  return nil
+}
+
+/* Value of the 4 hex digits at b[i:], or -1. */
+func hexRune(b []byte, i int) rune {
+  if i + 4 > len(b) { return -1 }
+  n, err := strconv.ParseUint(string(b[i:i+4]), 16, 16)
+  if err != nil { return -1 }
+  return rune(n)
+}
@@ -0,0 +1,281 @@
+/*
+  Reflection-based conversion of Go values to jase values.
+
+  Marshal follows the conventions of encoding/json:
+    - struct fields are named after their json tag (or the field name),
+      fields tagged "-" and unexported fields are skipped, the fields of
+      embedded structs are inlined;
+    - the "omitempty" option skips false, 0, "", nil pointers and
+      interfaces, empty slices and maps, and also SQL null values;
+    - the "string" option encodes integers as strings, as ids are
+      exported (view.ExportId);
+    - map keys are sorted;
+    - []byte is encoded in base64 and json.RawMessage is copied as is.
+  Object properties are written in field declaration order.
+
+  time.Time values are encoded with Time, values implementing
+  driver.Valuer (sql.NullInt64, mysql.NullTime, ...) are encoded as their
+  driver value (null if not valid), jase values are used as is, and types
+  can implement Marshaler to provide their own encoding.
+*/
+
+package jase
+
+import (
+  "database/sql/driver"
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+type Marshaler interface {
+  MarshalJase() (Value, error)
+}
+
+type structField struct {
+  name string
+  index []int
+  omitEmpty bool
+  asString bool
+}
+
+var structFieldsCache sync.Map /* reflect.Type → []structField */
+
+var (
+  valueType = reflect.TypeOf((*Value)(nil)).Elem()
+  marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+  valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+  timeType = reflect.TypeOf(time.Time{})
+  rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func Marshal(v interface{}) (Value, error) {
+  if v == nil { return Null, nil }
+  return marshalValue(reflect.ValueOf(v), false)
+}
+
+func marshalValue(v reflect.Value, asString bool) (Value, error) {
+  if !v.IsValid() { return Null, nil }
+  t := v.Type()
+  switch {
+  case t.Kind() == reflect.Ptr && v.IsNil():
+    return Null, nil
+  case t.Kind() == reflect.Interface:
+    if v.IsNil() { return Null, nil }
+    return marshalValue(v.Elem(), asString)
+  case t.Implements(valueType):
+    return v.Interface().(Value), nil
+  case t.Implements(marshalerType):
+    return v.Interface().(Marshaler).MarshalJase()
+  case t == timeType:
+    return Time(v.Interface().(time.Time)), nil
+  case t == rawMessageType:
+    if v.IsNil() { return Null, nil }
+    return Raw(v.Bytes()), nil
+  case t.Kind() == reflect.Struct && t.Implements(valuerType):
+    dv, err := v.Interface().(driver.Valuer).Value()
+    if err != nil { return nil, err }
+    return marshalValue(reflect.ValueOf(dv), asString)
+  }
+  switch t.Kind() {
+  case reflect.Bool:
+    return Boolean(v.Bool()), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    s := strconv.FormatInt(v.Int(), 10)
+    if asString { return String(s), nil }
+    return Raw([]byte(s)), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    s := strconv.FormatUint(v.Uint(), 10)
+    if asString { return String(s), nil }
+    return Raw([]byte(s)), nil
+  case reflect.Float32:
+    return Float32(float32(v.Float())), nil
+  case reflect.Float64:
+    return Float64(v.Float()), nil
+  case reflect.String:
+    return String(v.String()), nil
+  case reflect.Ptr:
+    return marshalValue(v.Elem(), asString)
+  case reflect.Struct:
+    return marshalStruct(v)
+  case reflect.Map:
+    return marshalMap(v)
+  case reflect.Slice:
+    if v.IsNil() { return Null, nil }
+    if t.Elem().Kind() == reflect.Uint8 {
+      return String(base64.StdEncoding.EncodeToString(v.Bytes())), nil
+    }
+    return marshalArray(v)
+  case reflect.Array:
+    return marshalArray(v)
+  }
+  return nil, fmt.Errorf("jase: cannot marshal type %s", t)
+}
+
+func marshalStruct(v reflect.Value) (Value, error) {
+  obj := Object()
+  for _, f := range cachedStructFields(v.Type()) {
+    fv, ok := fieldByIndex(v, f.index)
+    if !ok { continue } /* field of a nil embedded pointer */
+    if f.omitEmpty && isEmptyValue(fv) { continue }
+    val, err := marshalValue(fv, f.asString)
+    if err != nil { return nil, err }
+    obj.Prop(f.name, val)
+  }
+  return obj, nil
+}
+
+func marshalMap(v reflect.Value) (Value, error) {
+  if v.IsNil() { return Null, nil }
+  type entry struct {
+    key string
+    value reflect.Value
+  }
+  entries := make([]entry, 0, v.Len())
+  for _, k := range v.MapKeys() {
+    var key string
+    switch k.Kind() {
+    case reflect.String:
+      key = k.String()
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+      key = strconv.FormatInt(k.Int(), 10)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+      key = strconv.FormatUint(k.Uint(), 10)
+    default:
+      return nil, fmt.Errorf("jase: cannot marshal map key type %s", k.Type())
+    }
+    entries = append(entries, entry{key, v.MapIndex(k)})
+  }
+  sort.Slice(entries, func (i, j int) bool { return entries[i].key < entries[j].key })
+  obj := Object()
+  for _, e := range entries {
+    val, err := marshalValue(e.value, false)
+    if err != nil { return nil, err }
+    obj.Prop(e.key, val)
+  }
+  return obj, nil
+}
+
+func marshalArray(v reflect.Value) (Value, error) {
+  arr := Array()
+  for i := 0; i < v.Len(); i++ {
+    val, err := marshalValue(v.Index(i), false)
+    if err != nil { return nil, err }
+    arr.Item(val)
+  }
+  return arr, nil
+}
+
+/* Like reflect.Value.FieldByIndex, but reports nil embedded pointers
+   instead of panicking. */
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+  for i, x := range index {
+    if i > 0 && v.Kind() == reflect.Ptr {
+      if v.IsNil() { return reflect.Value{}, false }
+      v = v.Elem()
+    }
+    v = v.Field(x)
+  }
+  return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+  switch v.Kind() {
+  case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+    return v.Len() == 0
+  case reflect.Bool:
+    return !v.Bool()
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return v.Int() == 0
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    return v.Uint() == 0
+  case reflect.Float32, reflect.Float64:
+    return v.Float() == 0
+  case reflect.Interface, reflect.Ptr:
+    return v.IsNil()
+  case reflect.Struct:
+    if v.Type().Implements(valuerType) {
+      dv, err := v.Interface().(driver.Valuer).Value()
+      return err == nil && dv == nil
+    }
+  }
+  return false
+}
+
+func cachedStructFields(t reflect.Type) []structField {
+  if fields, ok := structFieldsCache.Load(t); ok {
+    return fields.([]structField)
+  }
+  fields, _ := structFieldsCache.LoadOrStore(t, typeFields(t, nil))
+  return fields.([]structField)
+}
+
+/* Lists the encoded fields of a struct type, inlining embedded structs
+   in place.  Fields of the outer struct hide inlined fields of the same
+   name. */
+func typeFields(t reflect.Type, index []int) []structField {
+  var fields []structField
+  names := make(map[string]bool)
+  inlined := make(map[int]bool) /* positions in fields */
+  for i := 0; i < t.NumField(); i++ {
+    sf := t.Field(i)
+    tag := sf.Tag.Get("json")
+    if tag == "-" { continue }
+    name, opts := parseTag(tag)
+    fieldIndex := make([]int, len(index) + 1)
+    copy(fieldIndex, index)
+    fieldIndex[len(index)] = i
+    if sf.Anonymous && name == "" {
+      ft := sf.Type
+      if ft.Kind() == reflect.Ptr { ft = ft.Elem() }
+      if ft.Kind() == reflect.Struct && ft != timeType && !ft.Implements(valuerType) {
+        for _, f := range typeFields(ft, fieldIndex) {
+          inlined[len(fields)] = true
+          fields = append(fields, f)
+        }
+        continue
+      }
+    }
+    if sf.PkgPath != "" { continue } /* unexported */
+    if name == "" { name = sf.Name }
+    names[name] = true
+    fields = append(fields, structField{
+      name: name,
+      index: fieldIndex,
+      omitEmpty: opts["omitempty"],
+      asString: opts["string"],
+    })
+  }
+  result := fields[:0]
+  for i, f := range fields {
+    if inlined[i] {
+      if names[f.name] { continue }
+      names[f.name] = true
+    }
+    result = append(result, f)
+  }
+  return result
+}
+
+func parseTag(tag string) (string, map[string]bool) {
+  parts := strings.Split(tag, ",")
+  opts := make(map[string]bool)
+  for _, opt := range parts[1:] {
+    opts[opt] = true
+  }
+  return parts[0], opts
+}
+
+/* Like Marshal, for use where a Value is expected.  Errors are reported
+   when the value is written. */
+func Marshaled(v interface{}) Value {
+  val, err := Marshal(v)
+  if err != nil { return &invalid{err} }
+  return val
+}
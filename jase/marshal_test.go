@@ -0,0 +1,129 @@
+package jase
+
+import (
+  "database/sql"
+  "encoding/json"
+  "testing"
+  "time"
+)
+
+type marshalBase struct {
+  Id int64 `json:"id,string"`
+  Name string `json:"name"`
+}
+
+type marshalPoint struct {
+  X, Y int
+}
+
+func (p marshalPoint) MarshalJase() (Value, error) {
+  arr := Array()
+  arr.Item(Int(p.X))
+  arr.Item(Int(p.Y))
+  return arr, nil
+}
+
+type marshalSample struct {
+  marshalBase
+  Name string `json:"title"` /* both names are kept */
+  Count uint32 `json:"count,omitempty"`
+  Empty string `json:"empty,omitempty"`
+  Parent sql.NullInt64 `json:"parent"`
+  Owner sql.NullInt64 `json:"owner,omitempty"`
+  Label sql.NullString `json:"label"`
+  Created time.Time `json:"created"`
+  Tags []string `json:"tags"`
+  NoTags []string `json:"noTags,omitempty"`
+  Scores map[string]float64 `json:"scores"`
+  Data []byte `json:"data"`
+  Raw json.RawMessage `json:"raw"`
+  Point marshalPoint `json:"point"`
+  Next *marshalSample `json:"next"`
+  Value Value `json:"value"`
+  Skipped int `json:"-"`
+  hidden int
+  Untagged bool
+}
+
+func marshalString(t *testing.T, v interface{}) string {
+  val, err := Marshal(v)
+  if err != nil { t.Fatal(err) }
+  bs, err := ToBytes(val)
+  if err != nil { t.Fatal(err) }
+  return string(bs)
+}
+
+func TestMarshal(t *testing.T) {
+  sample := marshalSample{
+    marshalBase: marshalBase{Id: 12, Name: "base"},
+    Name: "outer",
+    Parent: sql.NullInt64{Int64: 3, Valid: true},
+    Created: time.Date(2018, 11, 6, 9, 0, 0, 0, time.UTC),
+    Tags: []string{"a", "b"},
+    Scores: map[string]float64{"z": 1.5, "a": -2},
+    Data: []byte("hi"),
+    Raw: json.RawMessage(`{"k":[1]}`),
+    Point: marshalPoint{1, 2},
+    Value: String("v"),
+    Skipped: 1,
+    hidden: 2,
+    Untagged: true,
+  }
+  expected := `{"id":"12","name":"base","title":"outer","parent":3,"label":null,` +
+    `"created":"2018-11-06T09:00:00Z","tags":["a","b"],"scores":{"a":-2,"z":1.5},` +
+    `"data":"aGk=","raw":{"k":[1]},"point":[1,2],"next":null,"value":"v","Untagged":true}`
+  if s := marshalString(t, &sample); s != expected {
+    t.Errorf("expected\n%s\ngot\n%s", expected, s)
+  }
+}
+
+type marshalOuter struct {
+  *marshalBase
+  Name string `json:"name"` /* hides the embedded name */
+}
+
+func TestMarshalEmbedded(t *testing.T) {
+  if s := marshalString(t, marshalOuter{&marshalBase{Id: 1, Name: "inner"}, "outer"}); s != `{"id":"1","name":"outer"}` {
+    t.Errorf("got %s", s)
+  }
+  /* Fields of a nil embedded pointer are left out. */
+  if s := marshalString(t, marshalOuter{nil, "outer"}); s != `{"name":"outer"}` {
+    t.Errorf("got %s", s)
+  }
+}
+
+func TestMarshalValues(t *testing.T) {
+  cases := []struct {
+    value interface{}
+    output string
+  }{
+    {nil, `null`},
+    {(*marshalBase)(nil), `null`},
+    {[]int(nil), `null`},
+    {[]int{}, `[]`},
+    {[2]bool{true, false}, `[true,false]`},
+    {map[int]string{10: "b", 2: "a"}, `{"10":"b","2":"a"}`},
+    {"<\"é\">", `"<\"é\">"`},
+    {uint8(255), `255`},
+    {-0.5, `-0.5`},
+    {[]interface{}{1, "a", nil}, `[1,"a",null]`},
+  }
+  for _, c := range cases {
+    if s := marshalString(t, c.value); s != c.output {
+      t.Errorf("%#v: expected %s, got %s", c.value, c.output, s)
+    }
+  }
+}
+
+func TestMarshalErrors(t *testing.T) {
+  for _, v := range []interface{}{
+    make(chan int),
+    map[bool]int{true: 1},
+    struct{ F func() }{func () {}},
+  } {
+    if _, err := Marshal(v); err == nil { t.Errorf("%T: expected an error", v) }
+  }
+  if _, err := ToBytes(Marshaled(make(chan int))); err == nil {
+    t.Error("Marshaled: expected an error when written")
+  }
+}
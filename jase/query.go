@@ -0,0 +1,225 @@
+/*
+  Read side: queries on raw JSON documents.
+
+  Get returns the raw bytes of the value found by following a path (object
+  property names as strings, array indexes as ints) in a document:
+
+    state, err := j.Get(line, "message", "state")
+
+  The returned slice aliases the document (nothing is copied or decoded);
+  callers must copy it if the document's buffer is reused.  Only the parts
+  of the document on the way to the value are scanned, and they are only
+  checked for well-formed structure (matching brackets, terminated
+  strings), not fully validated.
+*/
+
+package jase
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+)
+
+type Kind int
+
+const (
+  InvalidKind Kind = iota
+  NullKind
+  BoolKind
+  NumberKind
+  StringKind
+  ArrayKind
+  ObjectKind
+)
+
+var ErrNotFound = errors.New("value not found")
+
+/* Returns the raw value at the given path in a document. */
+func Get(bs []byte, path ...interface{}) ([]byte, error) {
+  start, end, err := scanValue(bs, skipSpace(bs, 0))
+  if err != nil { return nil, err }
+  cur := bs[start:end]
+  for _, elem := range path {
+    var found []byte
+    switch key := elem.(type) {
+    case string:
+      err = eachProp(cur, func (rawKey []byte, value []byte) (bool, error) {
+        name, err := decodeKey(rawKey)
+        if err != nil { return false, err }
+        if name == key { found = value; return true, nil }
+        return false, nil
+      })
+    case int:
+      err = ForEach(cur, func (index int, item []byte) error {
+        if index == key { found = item; return errStop }
+        return nil
+      })
+      if err == errStop { err = nil }
+    default:
+      return nil, fmt.Errorf("bad path element %v", elem)
+    }
+    if err != nil { return nil, err }
+    if found == nil { return nil, ErrNotFound }
+    cur = found
+  }
+  return cur, nil
+}
+
+/* Returns the string at the given path in a document. */
+func GetString(bs []byte, path ...interface{}) (string, error) {
+  raw, err := Get(bs, path...)
+  if err != nil { return "", err }
+  if KindOf(raw) != StringKind { return "", errors.New("value is not a string") }
+  return decodeKey(raw)
+}
+
+/* Kind of a raw value, from its first byte. */
+func KindOf(raw []byte) Kind {
+  i := skipSpace(raw, 0)
+  if i == len(raw) { return InvalidKind }
+  switch raw[i] {
+  case 'n': return NullKind
+  case 't', 'f': return BoolKind
+  case '"': return StringKind
+  case '[': return ArrayKind
+  case '{': return ObjectKind
+  case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': return NumberKind
+  }
+  return InvalidKind
+}
+
+/* Calls fn with each item of a raw array, stopping at the first error. */
+func ForEach(raw []byte, fn func (index int, item []byte) error) error {
+  i := skipSpace(raw, 0)
+  if i == len(raw) || raw[i] != '[' { return errors.New("value is not an array") }
+  i = skipSpace(raw, i + 1)
+  if i < len(raw) && raw[i] == ']' { return nil }
+  for index := 0; ; index++ {
+    start, end, err := scanValue(raw, i)
+    if err != nil { return err }
+    err = fn(index, raw[start:end])
+    if err != nil { return err }
+    i = skipSpace(raw, end)
+    if i == len(raw) { return errSyntax }
+    if raw[i] == ']' { return nil }
+    if raw[i] != ',' { return errSyntax }
+    i = skipSpace(raw, i + 1)
+  }
+}
+
+/* Calls fn with each property of a raw object, stopping at the first
+   error. */
+func ForEachProp(raw []byte, fn func (key string, value []byte) error) error {
+  return eachProp(raw, func (rawKey []byte, value []byte) (bool, error) {
+    key, err := decodeKey(rawKey)
+    if err != nil { return false, err }
+    return false, fn(key, value)
+  })
+}
+
+var errSyntax = errors.New("malformed JSON")
+var errStop = errors.New("stop")
+
+func eachProp(raw []byte, fn func (rawKey []byte, value []byte) (bool, error)) error {
+  i := skipSpace(raw, 0)
+  if i == len(raw) || raw[i] != '{' { return errors.New("value is not an object") }
+  i = skipSpace(raw, i + 1)
+  if i < len(raw) && raw[i] == '}' { return nil }
+  for {
+    if i == len(raw) || raw[i] != '"' { return errSyntax }
+    keyEnd, err := scanString(raw, i)
+    if err != nil { return err }
+    rawKey := raw[i:keyEnd]
+    i = skipSpace(raw, keyEnd)
+    if i == len(raw) || raw[i] != ':' { return errSyntax }
+    start, end, err := scanValue(raw, skipSpace(raw, i + 1))
+    if err != nil { return err }
+    stop, err := fn(rawKey, raw[start:end])
+    if err != nil || stop { return err }
+    i = skipSpace(raw, end)
+    if i == len(raw) { return errSyntax }
+    if raw[i] == '}' { return nil }
+    if raw[i] != ',' { return errSyntax }
+    i = skipSpace(raw, i + 1)
+  }
+}
+
+/* Decodes a raw JSON string, without allocating for the escapes if there
+   are none. */
+func decodeKey(raw []byte) (string, error) {
+  for _, b := range raw[1:len(raw)-1] {
+    if b == '\\' {
+      var s string
+      err := json.Unmarshal(raw, &s)
+      return s, err
+    }
+  }
+  return string(raw[1:len(raw)-1]), nil
+}
+
+func skipSpace(bs []byte, i int) int {
+  for i < len(bs) {
+    switch bs[i] {
+    case ' ', '\t', '\n', '\r':
+      i++
+    default:
+      return i
+    }
+  }
+  return i
+}
+
+/* Returns the bounds of the value starting at bs[i]. */
+func scanValue(bs []byte, i int) (int, int, error) {
+  if i >= len(bs) { return 0, 0, errSyntax }
+  switch bs[i] {
+  case '"':
+    end, err := scanString(bs, i)
+    return i, end, err
+  case '{', '[':
+    var closers []byte
+    j := i
+    for j < len(bs) {
+      switch b := bs[j]; b {
+      case '"':
+        end, err := scanString(bs, j)
+        if err != nil { return 0, 0, err }
+        j = end
+        continue
+      case '{':
+        closers = append(closers, '}')
+      case '[':
+        closers = append(closers, ']')
+      case '}', ']':
+        if b != closers[len(closers)-1] { return 0, 0, errSyntax }
+        closers = closers[:len(closers)-1]
+        if len(closers) == 0 { return i, j + 1, nil }
+      }
+      j++
+    }
+    return 0, 0, errSyntax
+  default:
+    j := i
+    for j < len(bs) {
+      b := bs[j]
+      if b == ',' || b == '}' || b == ']' || b == ' ' || b == '\t' || b == '\n' || b == '\r' { break }
+      j++
+    }
+    if j == i || KindOf(bs[i:j]) == InvalidKind { return 0, 0, errSyntax }
+    return i, j, nil
+  }
+}
+
+/* Returns the end of the string starting at bs[i]. */
+func scanString(bs []byte, i int) (int, error) {
+  for j := i + 1; j < len(bs); j++ {
+    switch bs[j] {
+    case '\\':
+      j++
+    case '"':
+      return j + 1, nil
+    }
+  }
+  return 0, errSyntax
+}
@@ -0,0 +1,135 @@
+package jase
+
+import (
+  "reflect"
+  "testing"
+)
+
+var queryDoc = []byte(` {
+  "message": {"state": {"round": 3, "players": [[1, 2], [3, [4, 5]]]}},
+  "quo\"te": "q",
+  "\u0065scaped": "e",
+  "text": "line\n\"two\"",
+  "empty": {},
+  "none": [],
+  "null": null,
+  "flag": true
+} `)
+
+func TestGet(t *testing.T) {
+  cases := []struct {
+    path []interface{}
+    raw string
+  }{
+    {[]interface{}{"message", "state", "round"}, `3`},
+    {[]interface{}{"message", "state", "players", 1, 1, 0}, `4`},
+    {[]interface{}{"message", "state", "players", 0}, `[1, 2]`},
+    {[]interface{}{"quo\"te"}, `"q"`},
+    {[]interface{}{"escaped"}, `"e"`},
+    {[]interface{}{"empty"}, `{}`},
+    {[]interface{}{"none"}, `[]`},
+    {[]interface{}{"null"}, `null`},
+    {[]interface{}{"flag"}, `true`},
+  }
+  for _, c := range cases {
+    raw, err := Get(queryDoc, c.path...)
+    if err != nil { t.Errorf("%v: %v", c.path, err); continue }
+    if string(raw) != c.raw { t.Errorf("%v: expected %s, got %s", c.path, c.raw, raw) }
+  }
+  for _, path := range [][]interface{}{
+    {"missing"},
+    {"message", "state", "players", 2},
+    {"message", "state", "players", 1, 1, 2},
+    {"empty", "a"},
+    {"none", 0},
+  } {
+    if _, err := Get(queryDoc, path...); err != ErrNotFound {
+      t.Errorf("%v: expected ErrNotFound, got %v", path, err)
+    }
+  }
+  /* Traversing a value of the wrong kind is an error, not a miss. */
+  for _, path := range [][]interface{}{{"flag", "a"}, {"text", 0}, {"message", 0}} {
+    if _, err := Get(queryDoc, path...); err == nil || err == ErrNotFound {
+      t.Errorf("%v: expected a kind error, got %v", path, err)
+    }
+  }
+  if _, err := Get(queryDoc, 1.5); err == nil { t.Error("bad path element accepted") }
+}
+
+func TestGetString(t *testing.T) {
+  s, err := GetString(queryDoc, "text")
+  if err != nil { t.Fatal(err) }
+  if s != "line\n\"two\"" { t.Errorf("got %q", s) }
+  if _, err := GetString(queryDoc, "flag"); err == nil { t.Error("non-string accepted") }
+}
+
+/* Only the parts of a document on the way to the value are scanned. */
+func TestGetMalformed(t *testing.T) {
+  for _, doc := range []string{
+    ``,
+    `   `,
+    `{"a": 1`,
+    `{"a" 1}`,
+    `{"b": 1,}`,
+    `{a: 1}`,
+    `{"a": [1, 2}`,
+    `{"a": "unterminated}`,
+    `{"b": 1 "a": 2}`,
+    `[1 2]`,
+    `{"a": }`,
+    `{"a": @}`,
+  } {
+    if raw, err := Get([]byte(doc), "a"); err == nil {
+      t.Errorf("%q: expected an error, got %s", doc, raw)
+    }
+  }
+}
+
+func TestKindOf(t *testing.T) {
+  cases := map[string]Kind{
+    ` null`: NullKind,
+    `false`: BoolKind,
+    `-1.5`: NumberKind,
+    `"s"`: StringKind,
+    `[]`: ArrayKind,
+    `{}`: ObjectKind,
+    ``: InvalidKind,
+    `x`: InvalidKind,
+  }
+  for raw, kind := range cases {
+    if k := KindOf([]byte(raw)); k != kind { t.Errorf("%q: expected %d, got %d", raw, kind, k) }
+  }
+}
+
+func TestForEach(t *testing.T) {
+  var items []string
+  err := ForEach([]byte(` [1, "a,]", [2, [3]], {"b": [4]} ] `), func (index int, item []byte) error {
+    if index != len(items) { t.Errorf("bad index %d", index) }
+    items = append(items, string(item))
+    return nil
+  })
+  if err != nil { t.Fatal(err) }
+  expected := []string{`1`, `"a,]"`, `[2, [3]]`, `{"b": [4]}`}
+  if !reflect.DeepEqual(items, expected) { t.Errorf("got %q", items) }
+  if err := ForEach([]byte(`{}`), func (int, []byte) error { return nil }); err == nil {
+    t.Error("object iterated as an array")
+  }
+  if err := ForEach([]byte(`[1, 2`), func (int, []byte) error { return nil }); err == nil {
+    t.Error("unterminated array accepted")
+  }
+}
+
+func TestForEachProp(t *testing.T) {
+  var keys []string
+  err := ForEachProp(queryDoc, func (key string, value []byte) error {
+    keys = append(keys, key)
+    return nil
+  })
+  if err != nil { t.Fatal(err) }
+  expected := []string{"message", "quo\"te", "escaped", "text", "empty", "none", "null", "flag"}
+  if !reflect.DeepEqual(keys, expected) { t.Errorf("got %q", keys) }
+  stop := ErrNotFound
+  n := 0
+  err = ForEachProp(queryDoc, func (string, []byte) error { n++; return stop })
+  if err != stop || n != 1 { t.Errorf("iteration not stopped: %v after %d", err, n) }
+}
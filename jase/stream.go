@@ -0,0 +1,284 @@
+/*
+  Streaming encoder.
+
+  An Encoder writes a JSON document to an io.Writer as it is produced,
+  without building it in memory first:
+
+    enc := j.NewEncoder(w)
+    enc.BeginObject()
+    enc.Key("blocks")
+    enc.BeginArray()
+    for ... { enc.Value(block) }
+    enc.EndArray()
+    enc.EndObject()
+    err := enc.Flush()
+
+  Any Value can be written.  A pretty encoder (NewPrettyEncoder) indents
+  its output in a single pass, using the layout of PrettyBytes; Values
+  other than those built by Object, Array and the atom constructors are
+  re-indented as they are written.  Numbers are rewritten as PrettyBytes
+  does (as float64 values, so 1.5e10 becomes 1.5e+10); strings keep their
+  JSON escapes, where PrettyBytes writes them with Go's %q.
+
+  Output is buffered; Flush writes it out and also flushes the underlying
+  writer if it has a Flush method (as the writer passed by gin's c.Stream
+  does), so that what was encoded so far reaches the client.
+  The first error is kept: later calls do nothing and return it.
+*/
+
+package jase
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "strconv"
+  "strings"
+)
+
+type Encoder struct {
+  out *bufio.Writer
+  dst io.Writer
+  pretty bool
+  stack []encoderFrame
+  afterKey bool /* a key was written, its value is expected */
+  err error
+}
+
+type encoderFrame struct {
+  object bool
+  count int
+}
+
+type flusher interface {
+  Flush()
+}
+
+type errorFlusher interface {
+  Flush() error
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+  return &Encoder{out: bufio.NewWriter(w), dst: w}
+}
+
+func NewPrettyEncoder(w io.Writer) *Encoder {
+  enc := NewEncoder(w)
+  enc.pretty = true
+  return enc
+}
+
+func (e *Encoder) Err() error {
+  return e.err
+}
+
+func (e *Encoder) BeginObject() error {
+  return e.begin(true)
+}
+
+func (e *Encoder) EndObject() error {
+  return e.end('}', true)
+}
+
+func (e *Encoder) BeginArray() error {
+  return e.begin(false)
+}
+
+func (e *Encoder) EndArray() error {
+  return e.end(']', false)
+}
+
+/* Writes the key of the next property of the current object. */
+func (e *Encoder) Key(name string) error {
+  if e.err != nil { return e.err }
+  n := len(e.stack)
+  if n == 0 || !e.stack[n-1].object || e.afterKey {
+    return e.fail(errors.New("jase: unexpected key"))
+  }
+  e.item()
+  if _, err := String(name).WriteTo(e.out); err != nil { return e.fail(err) }
+  if e.pretty {
+    e.out.WriteString(": ")
+  } else {
+    e.out.WriteByte(':')
+  }
+  e.afterKey = true
+  return nil
+}
+
+/* Writes a value: the whole document, an array item, or the value of the
+   property whose key was just written. */
+func (e *Encoder) Value(v Value) error {
+  if e.err != nil { return e.err }
+  if err := e.beforeValue(); err != nil { return err }
+  if v == nil { v = Null }
+  if !e.pretty {
+    if _, err := v.WriteTo(e.out); err != nil { return e.fail(err) }
+    return nil
+  }
+  switch val := v.(type) {
+  case *object:
+    e.push(true)
+    for i := range val.props {
+      if e.Key(val.props[i].name) != nil { return e.err }
+      if e.Value(val.props[i].value) != nil { return e.err }
+    }
+    return e.EndObject()
+  case *array:
+    e.push(false)
+    for _, item := range val.items {
+      if e.Value(item) != nil { return e.err }
+    }
+    return e.EndArray()
+  case *atom:
+    return e.raw(val.raw)
+  default:
+    var b bytes.Buffer
+    if _, err := v.WriteTo(&b); err != nil { return e.fail(err) }
+    return e.raw(b.Bytes())
+  }
+}
+
+/* Writes out the buffered output. */
+func (e *Encoder) Flush() error {
+  if e.err != nil { return e.err }
+  if err := e.out.Flush(); err != nil { return e.fail(err) }
+  switch f := e.dst.(type) {
+  case errorFlusher:
+    if err := f.Flush(); err != nil { return e.fail(err) }
+  case flusher:
+    f.Flush()
+  }
+  return nil
+}
+
+func (e *Encoder) fail(err error) error {
+  e.err = err
+  return err
+}
+
+/* Checks that a value is expected and writes the separator before it. */
+func (e *Encoder) beforeValue() error {
+  n := len(e.stack)
+  if n != 0 {
+    if e.stack[n-1].object {
+      if !e.afterKey { return e.fail(errors.New("jase: missing key")) }
+      e.afterKey = false
+    } else {
+      e.item()
+    }
+  }
+  return nil
+}
+
+/* Writes the separator and indentation before an item or key. */
+func (e *Encoder) item() {
+  frame := &e.stack[len(e.stack)-1]
+  if frame.count != 0 {
+    e.out.WriteByte(',')
+  }
+  frame.count++
+  if e.pretty {
+    e.out.WriteByte('\n')
+    e.out.WriteString(strings.Repeat("  ", len(e.stack)))
+  }
+}
+
+func (e *Encoder) begin(object bool) error {
+  if e.err != nil { return e.err }
+  if err := e.beforeValue(); err != nil { return err }
+  e.push(object)
+  return nil
+}
+
+func (e *Encoder) push(object bool) {
+  if object {
+    e.out.WriteByte('{')
+  } else {
+    e.out.WriteByte('[')
+  }
+  e.stack = append(e.stack, encoderFrame{object: object})
+}
+
+func (e *Encoder) end(delim byte, object bool) error {
+  if e.err != nil { return e.err }
+  n := len(e.stack)
+  if n == 0 || e.stack[n-1].object != object || e.afterKey {
+    return e.fail(errors.New("jase: unexpected end of " + string(delim)))
+  }
+  count := e.stack[n-1].count
+  e.stack = e.stack[:n-1]
+  if e.pretty && count != 0 {
+    e.out.WriteByte('\n')
+    e.out.WriteString(strings.Repeat("  ", len(e.stack)))
+  }
+  e.out.WriteByte(delim)
+  return nil
+}
+
+/* Writes raw JSON text, which must be a single value.  Scalars other than
+   numbers are copied; in pretty mode, objects and arrays are re-indented. */
+func (e *Encoder) raw(bs []byte) error {
+  trimmed := bytes.TrimSpace(bs)
+  if !e.pretty || len(trimmed) == 0 {
+    e.out.Write(trimmed)
+    return nil
+  }
+  switch c := trimmed[0]; {
+  case c == '-' || ('0' <= c && c <= '9'):
+    return e.number(string(trimmed))
+  case c != '{' && c != '[':
+    e.out.Write(trimmed)
+    return nil
+  }
+  dec := json.NewDecoder(bytes.NewReader(trimmed))
+  dec.UseNumber()
+  /* The separator before the value has been written. */
+  if _, err := dec.Token(); err != nil { return e.fail(err) }
+  depth := len(e.stack)
+  e.push(trimmed[0] == '{')
+  for len(e.stack) > depth {
+    tok, err := dec.Token()
+    if err != nil { return e.fail(err) }
+    inObject := len(e.stack) > depth && e.stack[len(e.stack)-1].object
+    switch t := tok.(type) {
+    case json.Delim:
+      switch t {
+      case '{': err = e.begin(true)
+      case '[': err = e.begin(false)
+      case '}': err = e.end('}', true)
+      case ']': err = e.end(']', false)
+      }
+      if err != nil { return err }
+      continue
+    case string:
+      if inObject && !e.afterKey {
+        if e.Key(t) != nil { return e.err }
+        continue
+      }
+      if e.beforeValue() != nil { return e.err }
+      String(t).WriteTo(e.out)
+    case json.Number:
+      if e.beforeValue() != nil { return e.err }
+      if e.number(string(t)) != nil { return e.err }
+    case bool:
+      if e.beforeValue() != nil { return e.err }
+      Boolean(t).WriteTo(e.out)
+    case nil:
+      if e.beforeValue() != nil { return e.err }
+      Null.WriteTo(e.out)
+    }
+  }
+  return nil
+}
+
+/* Writes a number as PrettyBytes does. */
+func (e *Encoder) number(s string) error {
+  f, err := strconv.ParseFloat(s, 64)
+  if err != nil { return e.fail(err) }
+  fmt.Fprintf(e.out, "%v", f)
+  return nil
+}
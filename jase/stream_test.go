@@ -0,0 +1,156 @@
+package jase
+
+import (
+  "bytes"
+  "testing"
+)
+
+func streamSample() Value {
+  inner := Object()
+  inner.Prop("n", Float64(1.5e10))
+  inner.Prop("empty", Object())
+  inner.Prop("none", Array())
+  items := Array()
+  items.Item(Int(1))
+  items.Item(String("a\"b\n"))
+  items.Item(Null)
+  items.Item(inner)
+  items.Item(Raw([]byte(` { "k" : [ 1.5e10, -0.0, 100, {}, [], "x" ], "e": {} } `)))
+  items.Item(Raw([]byte(`2E3`)))
+  obj := Object()
+  obj.Prop("items", items)
+  obj.Prop("flag", Boolean(true))
+  obj.Prop("raw", Raw([]byte(`[[1],[2,[3]]]`)))
+  return obj
+}
+
+/* Writes streamSample with Begin/Key/Value calls. */
+func encodeSample(enc *Encoder) error {
+  sample := streamSample().(*object)
+  enc.BeginObject()
+  enc.Key("items")
+  enc.BeginArray()
+  for _, item := range sample.props[0].value.(*array).items {
+    enc.Value(item)
+  }
+  enc.EndArray()
+  for _, prop := range sample.props[1:] {
+    enc.Key(prop.name)
+    enc.Value(prop.value)
+  }
+  enc.EndObject()
+  return enc.Flush()
+}
+
+func TestEncoder(t *testing.T) {
+  expected, err := ToBytes(streamSample())
+  if err != nil { t.Fatal(err) }
+  var b bytes.Buffer
+  enc := NewEncoder(&b)
+  if err := enc.Value(streamSample()); err != nil { t.Fatal(err) }
+  if err := enc.Flush(); err != nil { t.Fatal(err) }
+  if b.String() != string(expected) {
+    t.Errorf("Value: expected\n%s\ngot\n%s", expected, b.String())
+  }
+  b.Reset()
+  if err := encodeSample(NewEncoder(&b)); err != nil { t.Fatal(err) }
+  if b.String() != string(expected) {
+    t.Errorf("calls: expected\n%s\ngot\n%s", expected, b.String())
+  }
+}
+
+func TestPrettyEncoder(t *testing.T) {
+  expected, err := ToPrettyBytes(streamSample())
+  if err != nil { t.Fatal(err) }
+  var b bytes.Buffer
+  enc := NewPrettyEncoder(&b)
+  if err := enc.Value(streamSample()); err != nil { t.Fatal(err) }
+  if err := enc.Flush(); err != nil { t.Fatal(err) }
+  if b.String() != string(expected) {
+    t.Errorf("Value: expected\n%s\ngot\n%s", expected, b.String())
+  }
+  b.Reset()
+  if err := encodeSample(NewPrettyEncoder(&b)); err != nil { t.Fatal(err) }
+  if b.String() != string(expected) {
+    t.Errorf("calls: expected\n%s\ngot\n%s", expected, b.String())
+  }
+  for _, v := range []Value{Int(3), Raw([]byte(" 1.5e10 ")), Object(), Array(), Raw([]byte(`{}`))} {
+    expected, err := ToPrettyBytes(v)
+    if err != nil { t.Fatal(err) }
+    b.Reset()
+    enc := NewPrettyEncoder(&b)
+    enc.Value(v)
+    if err := enc.Flush(); err != nil { t.Fatal(err) }
+    if b.String() != string(expected) { t.Errorf("expected %s, got %s", expected, b.String()) }
+  }
+}
+
+type countingFlusher struct {
+  bytes.Buffer
+  flushes int
+}
+
+func (w *countingFlusher) Flush() {
+  w.flushes++
+}
+
+func TestEncoderFlush(t *testing.T) {
+  var w countingFlusher
+  enc := NewEncoder(&w)
+  enc.BeginArray()
+  enc.Value(Int(1))
+  if w.Len() != 0 { t.Error("output not buffered") }
+  if err := enc.Flush(); err != nil { t.Fatal(err) }
+  if w.String() != "[1" || w.flushes != 1 { t.Errorf("got %q after %d flushes", w.String(), w.flushes) }
+}
+
+func TestEncoderErrors(t *testing.T) {
+  cases := map[string]func (enc *Encoder) error{
+    "key outside an object": func (enc *Encoder) error {
+      return enc.Key("a")
+    },
+    "key in an array": func (enc *Encoder) error {
+      enc.BeginArray()
+      return enc.Key("a")
+    },
+    "missing key": func (enc *Encoder) error {
+      enc.BeginObject()
+      return enc.Value(Int(1))
+    },
+    "two keys": func (enc *Encoder) error {
+      enc.BeginObject()
+      enc.Key("a")
+      return enc.Key("b")
+    },
+    "missing value": func (enc *Encoder) error {
+      enc.BeginObject()
+      enc.Key("a")
+      return enc.EndObject()
+    },
+    "mismatched end": func (enc *Encoder) error {
+      enc.BeginObject()
+      return enc.EndArray()
+    },
+    "end at top level": func (enc *Encoder) error {
+      return enc.EndObject()
+    },
+  }
+  for name, fn := range cases {
+    for _, pretty := range []bool{false, true} {
+      var b bytes.Buffer
+      enc := NewEncoder(&b)
+      enc.pretty = pretty
+      err := fn(enc)
+      if err == nil { t.Errorf("%s: expected an error", name); continue }
+      /* The first error is kept. */
+      if enc.Value(Int(1)) != err || enc.Flush() != err || enc.Err() != err {
+        t.Errorf("%s: error not kept", name)
+      }
+    }
+  }
+  for _, raw := range []string{`{"a": }`, `[1, 2`, `{"a": 1e400}`, `1e400`} {
+    var b bytes.Buffer
+    enc := NewPrettyEncoder(&b)
+    if enc.Value(Raw([]byte(raw))) == nil { t.Errorf("%s: expected an error", raw) }
+  }
+}
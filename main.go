@@ -3,14 +3,17 @@ package main
 
 import (
 
+  "context"
   "database/sql"
   "fmt"
   "html/template"
   "io"
   "os"
+  "os/signal"
   "io/ioutil"
   "log"
   "net/http"
+  "syscall"
   "time"
 
   "github.com/fatih/color"
@@ -29,10 +32,15 @@ import (
   cfg "tezos-contests.izibi.com/backend/config"
   "tezos-contests.izibi.com/backend/events"
   "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/pubsub"
   "tezos-contests.izibi.com/backend/routes"
+  "tezos-contests.izibi.com/backend/signing"
 
 )
 
+/* Time allowed for block builds to complete on shutdown. */
+var ShutdownTimeout = 30 * time.Second
+
 func buildRootTemplate() *template.Template {
   t := template.New("")
   auth.SetupTemplates(t)
@@ -60,20 +68,48 @@ func main() {
     config.Auth.FrontendOrigin = config.FrontendOrigin
   }
 
+  if config.ServerKey.Private == "" {
+    if config.Production {
+      log.Panicf("server_key must be set in production\n")
+    }
+    kp, err := signing.NewKeyPair()
+    if err != nil { panic(err) }
+    config.ServerKey.Public = kp.Public
+    config.ServerKey.Private = kp.Private
+    log.Printf("Using a temporary server key, receipts will not verify after a restart.\n")
+  }
+
   var db *sql.DB
-  db, err = sql.Open("mysql", config.DataSource)
+  var dataSource string
+  dataSource, err = model.SerializableDataSource(config.DataSource)
+  if err != nil {
+    log.Panicf("Bad database configuration: %s\n", err)
+  }
+  db, err = sql.Open("mysql", dataSource)
   if err != nil {
     log.Panicf("Failed to connect to database: %s\n", err)
   }
 
-  rc := redis.NewClient(&redis.Options{
-    Addr:     "localhost:6379",
-    Password: "", // no password set
-    DB:       0,  // use default DB
-  })
-  err = rc.Ping().Err()
-  if err != nil {
-    log.Panicf("Failed to connect to redis: %s\n", err)
+  var backend pubsub.Backend
+  switch config.PubSub.Backend {
+  case "memory":
+    backend = pubsub.NewMemory()
+  case "", "redis":
+    if config.PubSub.RedisAddr == "" {
+      config.PubSub.RedisAddr = "localhost:6379"
+    }
+    rc := redis.NewClient(&redis.Options{
+      Addr:     config.PubSub.RedisAddr,
+      Password: config.PubSub.RedisPassword,
+      DB:       config.PubSub.RedisDb,
+    })
+    err = rc.Ping().Err()
+    if err != nil {
+      log.Panicf("Failed to connect to redis: %s\n", err)
+    }
+    backend = pubsub.NewRedis(rc)
+  default:
+    log.Panicf("Unknown pubsub backend: %s\n", config.PubSub.Backend)
   }
 
   apiVersion := semver.MustParse(config.ApiVersion)
@@ -127,15 +163,18 @@ func main() {
   model := model.New(db)
   authService := auth.NewService(&config, model)
   authService.Route(router)
-  blockStore := blocks.NewService(&config, rc)
+  blockStore := blocks.NewService(&config, backend)
   blockStore.Route(router)
-  eventService, err := events.NewService(&config, rc, model, authService)
+  eventService, err := events.NewService(&config, backend, model, authService)
   if err != nil {
     log.Panicf("Failed to connect to create event service: %s\n", err)
   }
   go eventService.Run()
+  go eventService.RunWebhooks()
   eventService.Route(router)
-  routes.NewService(&config, rc, model, authService, eventService, blockStore).RouteAll(router)
+  routesService := routes.NewService(&config, backend, model, authService, eventService, blockStore)
+  routesService.RouteAll(router)
+  go routesService.RecoverBlockBuilds()
 
   router.GET("/ping", func(c *gin.Context) {
     c.String(http.StatusOK, "pong")
@@ -162,7 +201,7 @@ func main() {
 
 
   router.POST("/System/Ping", func(c *gin.Context) {
-    rc.Publish("system", "ping")
+    backend.Publish("system", "ping")
     c.String(200, "OK")
   })
 
@@ -200,5 +239,37 @@ func main() {
   })
 */
 
-  engine.Run(config.Listen)
+  server := &http.Server{Addr: config.Listen, Handler: engine}
+  go func () {
+    err := server.ListenAndServe()
+    if err != nil && err != http.ErrServerClosed {
+      log.Panicf("Failed to listen: %s\n", err)
+    }
+  }()
+
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+  sig := <-signals
+  log.Printf("Received %s, shutting down\n", sig)
+  ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+  defer cancel()
+  /* Event streams never go idle, they must be closed for the server to
+     complete its shutdown. */
+  eventService.Shutdown()
+  err = server.Shutdown(ctx)
+  if err != nil {
+    log.Printf("Server shutdown: %s\n", err)
+  }
+  err = routesService.Shutdown(ctx)
+  if err != nil {
+    log.Printf("Unfinished block builds: %s\n", err)
+  }
+  err = backend.Close()
+  if err != nil {
+    log.Printf("Failed to close pubsub backend: %s\n", err)
+  }
+  err = db.Close()
+  if err != nil {
+    log.Printf("Failed to close database: %s\n", err)
+  }
 }
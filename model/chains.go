@@ -3,8 +3,12 @@ package model
 
 import (
   "database/sql"
+  "fmt"
+  "strconv"
+  "strings"
   "time"
   "github.com/go-errors/errors"
+  "github.com/jmoiron/sqlx"
 )
 
 type Chain struct {
@@ -38,11 +42,19 @@ type ChainRevision struct {
   Protocol_hash string
 }
 
+/* Columns loaded when listing chains (protocol texts are left out). */
+const chainSummaryColumns = `id,created_at,updated_at,started_at,status_id,owner_id,title,game_key,parent_id,protocol_hash,nb_votes_approve,nb_votes_reject,nb_votes_unknown,contest_id`
+
 type ChainStatusFilter struct {
   Status string
   TeamId int64
 }
 
+/* FIXME hard-coded ids in select id from chain_statuses where is_public = 0 */
+func IsPublicChainStatus(statusId int64) bool {
+  return statusId != 1 && statusId != 6
+}
+
 func (m *Model) LoadChain(chainId int64) (*Chain, error) {
   var err error
   var chain Chain
@@ -51,10 +63,68 @@ func (m *Model) LoadChain(chainId int64) (*Chain, error) {
   return &chain, nil
 }
 
+/* Loads a chain and locks its row until the end of the transaction.  The
+   row is locked before it is read, so that the latest version is read. */
+func (m *Model) LoadChainForUpdate(chainId int64) (*Chain, error) {
+  var id int64
+  err := m.db.QueryRow(`SELECT id FROM chains WHERE id = ? FOR UPDATE`, chainId).Scan(&id)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return m.LoadChain(chainId)
+}
+
+/* Restricts the chains of another team to public chains. */
+type ChainVisibilityFilter struct {
+  TeamId int64
+}
+
+/* Selects chains owned by a team, or chains without owner if TeamId is
+   not valid. */
+type ChainOwnerFilter struct {
+  TeamId sql.NullInt64
+}
+
+/* Full-text search in the chains' title and description. */
+type ChainTextFilter struct {
+  Text string
+}
+
+type ChainParentFilter struct {
+  ParentId int64
+}
+
+/* Selects chains whose created_at, updated_at or started_at falls in the
+   range [After, Before), zero times leave the range unbounded. */
+type ChainDateFilter struct {
+  Field string /* "created", "updated", "started" */
+  After time.Time
+  Before time.Time
+}
+
+type ChainOrder struct {
+  By string /* "updated" (most recent first), "votes" (most approved first) */
+}
+
+/* Selects at most Limit chains following Cursor, as returned by
+   ChainCursor for the last chain of the previous page.  Limit can exceed
+   MaxChainPageSize by one, for the caller to find out whether there is a
+   next page. */
+type ChainPage struct {
+  Cursor string
+  Limit int
+}
+
+const (
+  DefaultChainPageSize = 50
+  MaxChainPageSize = 200
+)
+
 func (m *Model) LoadContestChains(contestId int64, filters... interface{}) ([]Chain, error) {
+  var err error
   var chains []Chain
-  query := `SELECT id,created_at,updated_at,started_at,status_id,owner_id,title,game_key,parent_id,protocol_hash,nb_votes_approve,nb_votes_reject,nb_votes_unknown,contest_id FROM chains WHERE contest_id = ?`
+  query := `SELECT `+chainSummaryColumns+` FROM chains WHERE contest_id = ?`
   args := []interface{}{contestId}
+  var order = ChainOrder{By: ""}
+  var page *ChainPage
   for _, f := range filters {
     switch filter := f.(type) {
     case ChainStatusFilter:
@@ -71,14 +141,183 @@ func (m *Model) LoadContestChains(contestId int64, filters... interface{}) ([]Ch
       case "past":
         query = query + ` AND status_id = 5`
       }
+    case ChainVisibilityFilter:
+      query = query + ` AND (status_id NOT IN (1, 6) OR owner_id = ?)`
+      args = append(args, filter.TeamId)
+    case ChainOwnerFilter:
+      if filter.TeamId.Valid {
+        query = query + ` AND owner_id = ?`
+        args = append(args, filter.TeamId.Int64)
+      } else {
+        query = query + ` AND owner_id IS NULL`
+      }
+    case ChainTextFilter:
+      query = query + ` AND MATCH (title, description_text) AGAINST (? IN NATURAL LANGUAGE MODE)`
+      args = append(args, filter.Text)
+    case ChainParentFilter:
+      query = query + ` AND parent_id = ?`
+      args = append(args, filter.ParentId)
+    case ChainDateFilter:
+      var column string
+      switch filter.Field {
+      case "created":
+        column = "created_at"
+      case "updated":
+        column = "updated_at"
+      case "started":
+        column = "started_at"
+      default:
+        return nil, errors.Errorf("bad date filter field: %s", filter.Field)
+      }
+      if !filter.After.IsZero() {
+        query = query + ` AND ` + column + ` >= ?`
+        args = append(args, filter.After)
+      }
+      if !filter.Before.IsZero() {
+        query = query + ` AND ` + column + ` < ?`
+        args = append(args, filter.Before)
+      }
+    case ChainOrder:
+      order = filter
+    case ChainPage:
+      page = &filter
+    default:
+      return nil, errors.Errorf("unhandled chain filter %T", f)
     }
   }
+  if page != nil {
+    if page.Cursor != "" {
+      var cursor *chainCursor
+      cursor, err = parseChainCursor(page.Cursor)
+      if err != nil { return nil, err }
+      if cursor.order != order.By {
+        return nil, errors.New("cursor does not match sort order")
+      }
+      switch order.By {
+      case "updated":
+        query = query + ` AND (updated_at < ? OR (updated_at = ? AND id < ?))`
+        args = append(args, cursor.updatedAt, cursor.updatedAt, cursor.id)
+      case "votes":
+        query = query + ` AND (nb_votes_approve < ? OR (nb_votes_approve = ? AND id < ?))`
+        args = append(args, cursor.votes, cursor.votes, cursor.id)
+      default:
+        query = query + ` AND id > ?`
+        args = append(args, cursor.id)
+      }
+    }
+  }
+  switch order.By {
+  case "updated":
+    query = query + ` ORDER BY updated_at DESC, id DESC`
+  case "votes":
+    query = query + ` ORDER BY nb_votes_approve DESC, id DESC`
+  case "":
+    query = query + ` ORDER BY id`
+  default:
+    return nil, errors.Errorf("bad chain order: %s", order.By)
+  }
+  if page != nil {
+    limit := page.Limit
+    if limit <= 0 { limit = DefaultChainPageSize }
+    if limit > MaxChainPageSize + 1 { limit = MaxChainPageSize + 1 }
+    query = query + ` LIMIT ?`
+    args = append(args, limit)
+  }
   // fmt.Printf("query %s %v\n", query, args)
-  err := m.dbMap.Select(&chains, query, args...)
+  err = m.dbMap.Select(&chains, query, args...)
   if err != nil { return nil, errors.Wrap(err, 0) }
   return chains, nil
 }
 
+type chainCursor struct {
+  order string
+  id int64
+  updatedAt time.Time
+  votes int
+}
+
+/* ChainCursor returns the cursor to pass in ChainPage to load the chains
+   that follow the given chain in the given order. */
+func ChainCursor(chain *Chain, order string) string {
+  switch order {
+  case "updated":
+    return fmt.Sprintf("u%d_%d", chain.Updated_at.Unix(), chain.Id)
+  case "votes":
+    return fmt.Sprintf("v%d_%d", chain.Nb_votes_approve, chain.Id)
+  default:
+    return fmt.Sprintf("i%d", chain.Id)
+  }
+}
+
+func parseChainCursor(s string) (*chainCursor, error) {
+  var err error
+  var res chainCursor
+  var n int64
+  if len(s) < 2 { return nil, errors.New("bad cursor") }
+  parts := strings.SplitN(s[1:], "_", 2)
+  last := parts[len(parts) - 1]
+  res.id, err = strconv.ParseInt(last, 10, 64)
+  if err != nil { return nil, errors.New("bad cursor") }
+  switch {
+  case s[0] == 'u' && len(parts) == 2:
+    res.order = "updated"
+    n, err = strconv.ParseInt(parts[0], 10, 64)
+    res.updatedAt = time.Unix(n, 0)
+  case s[0] == 'v' && len(parts) == 2:
+    res.order = "votes"
+    n, err = strconv.ParseInt(parts[0], 10, 32)
+    res.votes = int(n)
+  case s[0] == 'i' && len(parts) == 1:
+    res.order = ""
+  default:
+    return nil, errors.New("bad cursor")
+  }
+  if err != nil { return nil, errors.New("bad cursor") }
+  return &res, nil
+}
+
+/* LoadChainAncestors returns the parents of a chain, nearest first. */
+func (m *Model) LoadChainAncestors(chain *Chain) ([]Chain, error) {
+  var chains []Chain
+  seen := map[int64]bool{chain.Id: true}
+  parentId := chain.Parent_id
+  for parentId.Valid && !seen[parentId.Int64] {
+    var parent Chain
+    err := m.dbMap.SelectOne(&parent,
+      `SELECT `+chainSummaryColumns+` FROM chains WHERE id = ?`, parentId.Int64)
+    if err == sql.ErrNoRows { break }
+    if err != nil { return nil, errors.Wrap(err, 0) }
+    seen[parent.Id] = true
+    chains = append(chains, parent)
+    parentId = parent.Parent_id
+  }
+  return chains, nil
+}
+
+/* LoadChainDescendants returns the chains forked (directly or not) from a
+   chain, in breadth-first order. */
+func (m *Model) LoadChainDescendants(chainId int64) ([]Chain, error) {
+  var chains []Chain
+  seen := map[int64]bool{chainId: true}
+  ids := []int64{chainId}
+  for len(ids) != 0 {
+    var children []Chain
+    query, args, err := sqlx.In(
+      `SELECT `+chainSummaryColumns+` FROM chains WHERE parent_id IN (?) ORDER BY id`, ids)
+    if err != nil { return nil, errors.Wrap(err, 0) }
+    err = m.dbMap.Select(&children, query, args...)
+    if err != nil { return nil, errors.Wrap(err, 0) }
+    ids = nil
+    for _, child := range children {
+      if seen[child.Id] { continue }
+      seen[child.Id] = true
+      chains = append(chains, child)
+      ids = append(ids, child.Id)
+    }
+  }
+  return chains, nil
+}
+
 func (m *Model) ForkChain(teamId int64, chainId int64, title string) (int64, error) {
   var err error
   var chain Chain
@@ -89,8 +328,8 @@ func (m *Model) ForkChain(teamId int64, chainId int64, title string) (int64, err
     Created_at: now,
     Updated_at: now,
     Contest_id: chain.Contest_id,
-    Owner_id: sql.NullInt64{teamId, true},
-    Parent_id: sql.NullInt64{chain.Id, true},
+    Owner_id: sql.NullInt64{Int64: teamId, Valid: true},
+    Parent_id: sql.NullInt64{Int64: chain.Id, Valid: true},
     Status_id: 1 /* private test */,
     Title: title,
     Description: chain.Description,
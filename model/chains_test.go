@@ -0,0 +1,45 @@
+package model
+
+import (
+  "testing"
+  "time"
+)
+
+func TestChainCursor(t *testing.T) {
+  chain := Chain{
+    Id: 42,
+    Updated_at: time.Unix(1541494800, 0),
+    Nb_votes_approve: 7,
+  }
+  cases := []struct {
+    order string
+    cursor string
+  }{
+    {"updated", "u1541494800_42"},
+    {"votes", "v7_42"},
+    {"", "i42"},
+  }
+  for _, c := range cases {
+    s := ChainCursor(&chain, c.order)
+    if s != c.cursor { t.Errorf("order %q: expected cursor %q, got %q", c.order, c.cursor, s) }
+    cursor, err := parseChainCursor(s)
+    if err != nil { t.Errorf("cursor %q: %v", s, err); continue }
+    if cursor.order != c.order || cursor.id != chain.Id {
+      t.Errorf("cursor %q: decoded as %+v", s, cursor)
+    }
+    if c.order == "updated" && !cursor.updatedAt.Equal(chain.Updated_at) {
+      t.Errorf("cursor %q: expected time %v, got %v", s, chain.Updated_at, cursor.updatedAt)
+    }
+    if c.order == "votes" && cursor.votes != chain.Nb_votes_approve {
+      t.Errorf("cursor %q: expected %d votes, got %d", s, chain.Nb_votes_approve, cursor.votes)
+    }
+  }
+}
+
+func TestParseBadChainCursor(t *testing.T) {
+  for _, s := range []string{"", "u", "x1_2", "u1", "i1_2", "v1_x", "ux_1", "v99999999999_1", "i"} {
+    if _, err := parseChainCursor(s); err == nil {
+      t.Errorf("cursor %q should be rejected", s)
+    }
+  }
+}
@@ -2,6 +2,7 @@
 package model
 
 import (
+  "database/sql"
   "github.com/go-errors/errors"
 )
 
@@ -18,6 +19,9 @@ type Contest struct {
   Ends_at string
   Required_badge_id int64
   // Contest_period_id string
+  Max_private_chains sql.NullInt64
+  Max_running_games sql.NullInt64
+  Max_store_bytes sql.NullInt64
 }
 
 func (m *Model) LoadContest(id int64) (*Contest, error) {
@@ -8,7 +8,7 @@ import (
   "time"
   "github.com/go-sql-driver/mysql"
   "github.com/go-errors/errors"
-  ji "github.com/json-iterator/go"
+  "github.com/jmoiron/sqlx"
   j "tezos-contests.izibi.com/backend/jase"
   "tezos-contests.izibi.com/backend/utils"
 )
@@ -29,6 +29,7 @@ type Game struct {
   Max_nb_rounds uint64
   Max_nb_players uint32
   Nb_cycles_per_round uint32
+  Needs_recovery bool
 }
 
 type GameParams struct {
@@ -97,6 +98,43 @@ func (m *Model) IsGameOwner(gameKey string, teamId int64) (bool, error) {
   return true, nil
 }
 
+/* A team can view a game it owns or plays in, and any game attached to a
+   chain it can view. */
+func (m *Model) CanTeamViewGame(teamId int64, gameKey string) (bool, error) {
+  row := m.db.QueryRow(
+    `SELECT COUNT(*) FROM games g WHERE g.game_key = ? AND (
+       g.owner_id = ? OR
+       EXISTS (SELECT 1 FROM game_players gp WHERE gp.game_id = g.id AND gp.team_id = ?) OR
+       EXISTS (SELECT 1 FROM chains c, teams t
+               WHERE c.game_key = g.game_key AND t.id = ? AND c.contest_id = t.contest_id
+                 AND (c.status_id NOT IN (1, 6) OR c.owner_id = t.id)))`,
+    gameKey, teamId, teamId, teamId)
+  var count int
+  err := row.Scan(&count)
+  if err != nil { return false, errors.Wrap(err, 0) }
+  return count != 0, nil
+}
+
+/* A user can view the games viewable by one of their teams, and the games
+   attached to public chains of the contests they have access to. */
+func (m *Model) CanUserViewGame(userId int64, gameKey string) (bool, error) {
+  if m.IsUserAdmin(userId) { return true, nil }
+  row := m.db.QueryRow(
+    `SELECT COUNT(*) FROM games g WHERE g.game_key = ? AND (
+       EXISTS (SELECT 1 FROM team_members tm WHERE tm.user_id = ? AND (
+         tm.team_id = g.owner_id OR
+         EXISTS (SELECT 1 FROM game_players gp WHERE gp.game_id = g.id AND gp.team_id = tm.team_id) OR
+         EXISTS (SELECT 1 FROM chains c WHERE c.game_key = g.game_key AND c.owner_id = tm.team_id))) OR
+       EXISTS (SELECT 1 FROM chains c, contests ct, user_badges ub
+               WHERE c.game_key = g.game_key AND c.status_id NOT IN (1, 6)
+                 AND ct.id = c.contest_id AND ub.user_id = ? AND ub.badge_id = ct.required_badge_id))`,
+    gameKey, userId, userId)
+  var count int
+  err := row.Scan(&count)
+  if err != nil { return false, errors.Wrap(err, 0) }
+  return count != 0, nil
+}
+
 func (m *Model) CreateGame(ownerId int64, firstBlock string, params GameParams) (string, error) {
   var err error
   gameKey, err := utils.NewKey()
@@ -126,11 +164,14 @@ func (m *Model) CreateGame(ownerId int64, firstBlock string, params GameParams)
 func (m *Model) RegisterGamePlayers(gameKey string, teamId int64, botIds []uint32) ([]uint32, error) {
   var err error
   var game *Game
-  game, err = m.LoadGame(gameKey)
+  /* Lock the game so that concurrent registrations (in transactions) see
+     each other's players and cannot exceed the maximum number of players. */
+  game, err = m.loadGameForUpdate(gameKey)
   if err != nil { return nil, err }
   if game == nil { return nil, errors.New("bad game key") }
   var ps []RegisteredGamePlayer
-  ps, err = m.LoadRegisteredGamePlayer(game.Id)
+  ps, err = m.loadRegisteredGamePlayersForUpdate(game.Id)
+  if err != nil { return nil, err }
   var ranks []uint32
   var nextRank uint32 = uint32(len(ps)) + 1
   bot_loop: for _, botId := range botIds {
@@ -156,14 +197,21 @@ func (m *Model) RegisterGamePlayers(gameKey string, teamId int64, botIds []uint3
   return ranks, nil
 }
 
-func (m *Model) SetPlayerCommands(gameKey string, currentBlock string, teamId int64, teamPlayer uint32, commands []byte) (err error) {
+/* Returns the game and the rank of the player. */
+func (m *Model) SetPlayerCommands(gameKey string, currentBlock string, teamId int64, teamPlayer uint32, commands []byte) (*Game, uint32, error) {
   game, err := m.LoadGame(gameKey)
-  if err != nil { return err }
+  if err != nil { return nil, 0, err }
+  if game == nil { return nil, 0, errors.New("bad game key") }
   if game.Last_block != currentBlock {
-    return errors.New("current block has changed")
+    return nil, 0, errors.New("current block has changed")
   }
   fmt.Printf("setPlayerCommands %d %d %d\n", game.Id, teamId, teamPlayer)
-  return m.setPlayerCommands(game.Id, teamId, teamPlayer, commands)
+  rank, err := m.getPlayerRank(game.Id, teamId, teamPlayer)
+  if err != nil { return nil, 0, errors.Wrap(err, 0) }
+  if rank == 0 { return nil, 0, errors.New("player is not registered") }
+  err = m.setPlayerCommands(game.Id, teamId, teamPlayer, commands)
+  if err != nil { return nil, 0, err }
+  return game, rank, nil
 }
 
 func (m *Model) CloseRound(gameKey string, currentBlock string) (*Game, error) {
@@ -197,7 +245,7 @@ func (m *Model) CancelRound(gameKey string) (*Game, error) {
     `UPDATE game_players SET locked_at = NULL WHERE game_id = ?`, game.Id)
   if err != nil { return game, err }
   _, err = m.db.Exec(
-    `UPDATE games SET locked = 0, updated_at = NOW() WHERE id = ?`, game.Id)
+    `UPDATE games SET locked = 0, needs_recovery = 0, updated_at = NOW() WHERE id = ?`, game.Id)
   if err != nil { return game, errors.Wrap(err, 0) }
   game.Locked = false
   return game, nil
@@ -216,6 +264,7 @@ func (m *Model) EndRoundAndUnlock(gameKey string, newBlock string) (*Game, error
   _, err = m.db.Exec(
     `UPDATE games SET
       locked = 0,
+      needs_recovery = 0,
       current_round = current_round + 1,
       last_block = ?,
       next_block_commands = "",
@@ -229,6 +278,36 @@ func (m *Model) EndRoundAndUnlock(gameKey string, newBlock string) (*Game, error
   return game, nil
 }
 
+/* Flags locked games whose next block was left unfinished at shutdown. */
+func (m *Model) MarkGamesForRecovery(gameKeys []string) error {
+  if len(gameKeys) == 0 { return nil }
+  query, args, err := sqlx.In(
+    `UPDATE games SET needs_recovery = 1 WHERE locked AND game_key IN (?)`, gameKeys)
+  if err != nil { return errors.Wrap(err, 0) }
+  _, err = m.db.Exec(query, args...)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+/* Claims a game flagged for recovery, clearing its flag so that a single
+   instance resumes it.  Returns nil if there is none. */
+func (m *Model) ClaimGameRecovery() (*Game, error) {
+  for {
+    var game Game
+    err := m.dbMap.SelectOne(&game,
+      `SELECT * FROM games WHERE needs_recovery AND locked LIMIT 1`)
+    if err == sql.ErrNoRows { return nil, nil }
+    if err != nil { return nil, errors.Wrap(err, 0) }
+    res, err := m.db.Exec(
+      `UPDATE games SET needs_recovery = 0 WHERE id = ? AND needs_recovery`, game.Id)
+    if err != nil { return nil, errors.Wrap(err, 0) }
+    if n, err := res.RowsAffected(); err == nil && n == 1 {
+      game.Needs_recovery = false
+      return &game, nil
+    }
+  }
+}
+
 /*
 func (m *Model) getGameId(gameKey string) (string, error) {
   row := m.db.QueryRow(`SELECT id FROM games WHERE game_key = ?`, gameKey)
@@ -240,9 +319,19 @@ func (m *Model) getGameId(gameKey string) (string, error) {
 */
 
 func (m *Model) LoadRegisteredGamePlayer(gameId int64) ([]RegisteredGamePlayer, error) {
+  return m.loadRegisteredGamePlayers(gameId, "")
+}
+
+/* A locking read returns the latest players even if the transaction's
+   snapshot is older. */
+func (m *Model) loadRegisteredGamePlayersForUpdate(gameId int64) ([]RegisteredGamePlayer, error) {
+  return m.loadRegisteredGamePlayers(gameId, " FOR UPDATE")
+}
+
+func (m *Model) loadRegisteredGamePlayers(gameId int64, suffix string) ([]RegisteredGamePlayer, error) {
   var err error
   rows, err := m.db.Queryx(
-    `SELECT rank, team_id, team_player FROM game_players WHERE game_id = ? ORDER by rank`, gameId)
+    `SELECT rank, team_id, team_player FROM game_players WHERE game_id = ? ORDER by rank` + suffix, gameId)
   if err != nil { return nil, errors.Wrap(err, 0) }
   defer rows.Close()
   var ps []RegisteredGamePlayer
@@ -338,7 +427,8 @@ func (m *Model) getNextBlockCommands (gameId int64, nbCycles uint32) ([]byte, er
     for i, cmd := range input.Commands {
       obj := j.Object()
       obj.Prop("player", j.Uint32(player.Rank))
-      obj.Prop("command", j.String(ji.Get(cmd, "text").ToString()))
+      text, _ := j.GetString(cmd, "text")
+      obj.Prop("command", j.String(text))
       cycles[i].Item(obj)
     }
   }
@@ -0,0 +1,132 @@
+package model
+
+import (
+  "context"
+  "fmt"
+  "sync"
+  "testing"
+  "github.com/go-errors/errors"
+  "github.com/go-sql-driver/mysql"
+)
+
+func createTestTeams(t *testing.T, n int) []int64 {
+  ids := make([]int64, n)
+  for i := range ids {
+    res, err := db.Exec(
+      `INSERT INTO teams (access_code, contest_id, is_open, is_locked, name)
+       VALUES (?, 0, 1, 0, ?)`, fmt.Sprintf("code-%s-%d", t.Name(), i), fmt.Sprintf("team %d", i))
+    if err != nil { t.Fatal(err) }
+    ids[i], err = res.LastInsertId()
+    if err != nil { t.Fatal(err) }
+  }
+  return ids
+}
+
+func TestConcurrentRegisterGamePlayers(t *testing.T) {
+  const maxPlayers = 4
+  model := New(db)
+  teamIds := createTestTeams(t, 8)
+  gameKey, err := model.CreateGame(teamIds[0], "first-block", GameParams{
+    Nb_rounds: 10,
+    Nb_players: maxPlayers,
+    Cycles_per_round: 1,
+  })
+  if err != nil { t.Fatal(err) }
+  var wg sync.WaitGroup
+  errs := make([]error, len(teamIds))
+  for i, teamId := range teamIds {
+    wg.Add(1)
+    go func (i int, teamId int64) {
+      defer wg.Done()
+      errs[i] = model.Transaction(context.Background(), func (tx *Tx) error {
+        _, err := tx.RegisterGamePlayers(gameKey, teamId, []uint32{1, 2})
+        return err
+      })
+    }(i, teamId)
+  }
+  wg.Wait()
+  for i, err := range errs {
+    if err != nil { t.Errorf("team %d: %v", i, err) }
+  }
+  game, err := model.LoadGame(gameKey)
+  if err != nil { t.Fatal(err) }
+  players, err := model.LoadRegisteredGamePlayer(game.Id)
+  if err != nil { t.Fatal(err) }
+  if len(players) != maxPlayers {
+    t.Fatalf("expected %d players, got %d", maxPlayers, len(players))
+  }
+  for i, player := range players {
+    if player.Rank != uint32(i + 1) {
+      t.Errorf("expected rank %d, got %d", i + 1, player.Rank)
+    }
+  }
+}
+
+func TestTransactionRollback(t *testing.T) {
+  model := New(db)
+  teamIds := createTestTeams(t, 1)
+  gameKey, err := model.CreateGame(teamIds[0], "first-block", GameParams{
+    Nb_rounds: 10,
+    Nb_players: 2,
+    Cycles_per_round: 1,
+  })
+  if err != nil { t.Fatal(err) }
+  failure := errors.New("failure")
+  err = model.Transaction(context.Background(), func (tx *Tx) error {
+    _, err := tx.RegisterGamePlayers(gameKey, teamIds[0], []uint32{1})
+    if err != nil { return err }
+    return failure
+  })
+  if err != failure { t.Fatalf("expected the callback's error, got %v", err) }
+  game, err := model.LoadGame(gameKey)
+  if err != nil { t.Fatal(err) }
+  players, err := model.LoadRegisteredGamePlayer(game.Id)
+  if err != nil { t.Fatal(err) }
+  if len(players) != 0 {
+    t.Fatalf("expected the registration to be rolled back, got %d players", len(players))
+  }
+}
+
+func TestSavepoint(t *testing.T) {
+  model := New(db)
+  teamIds := createTestTeams(t, 2)
+  gameKey, err := model.CreateGame(teamIds[0], "first-block", GameParams{
+    Nb_rounds: 10,
+    Nb_players: 4,
+    Cycles_per_round: 1,
+  })
+  if err != nil { t.Fatal(err) }
+  failure := errors.New("failure")
+  err = model.Transaction(context.Background(), func (tx *Tx) error {
+    cbErr, err := tx.Savepoint("first", func () error {
+      _, err := tx.RegisterGamePlayers(gameKey, teamIds[0], []uint32{1})
+      return err
+    })
+    if err != nil || cbErr != nil { return errors.Errorf("%v %v", cbErr, err) }
+    cbErr, err = tx.Savepoint("second", func () error {
+      _, err := tx.RegisterGamePlayers(gameKey, teamIds[1], []uint32{1, 2})
+      if err != nil { return err }
+      return failure
+    })
+    if err != nil { return err }
+    if cbErr != failure { return errors.Errorf("expected the callback's error, got %v", cbErr) }
+    return nil
+  })
+  if err != nil { t.Fatal(err) }
+  game, err := model.LoadGame(gameKey)
+  if err != nil { t.Fatal(err) }
+  players, err := model.LoadRegisteredGamePlayer(game.Id)
+  if err != nil { t.Fatal(err) }
+  if len(players) != 1 || players[0].Team_id != teamIds[0] {
+    t.Fatalf("expected only the first registration to be kept, got %+v", players)
+  }
+}
+
+func TestIsRetryableError(t *testing.T) {
+  deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+  if !IsRetryableError(deadlock) { t.Error("deadlock should be retried") }
+  if !IsRetryableError(errors.Wrap(deadlock, 0)) { t.Error("wrapped deadlock should be retried") }
+  duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+  if IsRetryableError(duplicate) { t.Error("duplicate entry should not be retried") }
+  if IsRetryableError(errors.New("bad game key")) { t.Error("other errors should not be retried") }
+}
@@ -2,44 +2,168 @@
 package model
 
 import (
+  "context"
   "database/sql"
+  "math/rand"
+  "time"
   "github.com/jmoiron/sqlx"
   "github.com/jmoiron/modl"
   "github.com/go-errors/errors"
-  "context"
-  _ "github.com/go-sql-driver/mysql"
+  "github.com/go-sql-driver/mysql"
 )
 
 type Model struct {
-  db *sqlx.DB
-  dbMap *modl.DbMap
+  db dbExecutor /* *sqlx.DB, or *sqlx.Tx in a transaction */
+  dbMap modl.SqlExecutor /* *modl.DbMap, or *modl.Transaction */
   tables Tables
+  conn *modl.DbMap /* nil in a transaction */
+}
+
+/* A model whose methods all run in the same database transaction, as
+   passed to the callback of Transaction. */
+type Tx struct {
+  Model
+}
+
+/* The sqlx methods used by the model, common to sqlx.DB and sqlx.Tx. */
+type dbExecutor interface {
+  Exec(query string, args ...interface{}) (sql.Result, error)
+  Query(query string, args ...interface{}) (*sql.Rows, error)
+  QueryRow(query string, args ...interface{}) *sql.Row
+  Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
+  QueryRowx(query string, args ...interface{}) *sqlx.Row
+  Select(dest interface{}, query string, args ...interface{}) error
 }
 
+/* Number of times a transaction is attempted when it fails because of
+   a deadlock or a lock wait timeout. */
+const MaxTransactionAttempts = 5
+
+var TransactionRetryDelay = 20 * time.Millisecond
+
+/* Sets the isolation level of the connections opened with a MySQL data
+   source name to SERIALIZABLE, which Transaction relies on. */
+func SerializableDataSource(dataSource string) (string, error) {
+  cfg, err := mysql.ParseDSN(dataSource)
+  if err != nil { return "", errors.Wrap(err, 0) }
+  if cfg.Params == nil { cfg.Params = make(map[string]string) }
+  cfg.Params["transaction_isolation"] = "'SERIALIZABLE'"
+  return cfg.FormatDSN(), nil
+}
+
+/* The database must be opened with a data source name passed through
+   SerializableDataSource. */
 func New (db *sql.DB) *Model {
   model := new(Model)
   if err := db.Ping(); err != nil {
     panic("database is unreachable")
   }
-  model.db = sqlx.NewDb(db, "mysql")
-  model.dbMap = modl.NewDbMap(db, modl.MySQLDialect{"InnoDB", "UTF8"})
-  model.tables.Map(model.dbMap)
+  var isolation string
+  if err := db.QueryRow("SELECT @@transaction_isolation").Scan(&isolation); err != nil || isolation != "SERIALIZABLE" {
+    panic("database connections are not serializable, see SerializableDataSource")
+  }
+  dbx := sqlx.NewDb(db, "mysql")
+  model.db = dbx
+  model.conn = modl.NewDbMap(db, modl.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"})
+  model.dbMap = model.conn
+  model.tables.Map(model.conn)
   return model
 }
 
-func (m *Model) Transaction(ctx context.Context, cb func () error) error {
-  tx, err := m.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+/*
+  Runs cb in a serializable database transaction, committed if cb returns
+  nil and rolled back otherwise (also when ctx is canceled before the
+  commit, so callers pass the request's context).  The model methods
+  called on the Tx passed to cb all run in the transaction.
+  Rows that are read to be updated are read with SELECT ... FOR UPDATE (as
+  loadGameForUpdate does), which takes an exclusive lock at once rather
+  than the shared lock of a serializable read, and so avoids deadlocks.
+  If the transaction fails because of a deadlock or a lock wait timeout, it
+  is rolled back and cb is called again (up to MaxTransactionAttempts
+  times), so cb must not have side effects outside the database.
+  Calling Transaction on a Tx runs cb in the same transaction.
+*/
+func (m *Model) Transaction(ctx context.Context, cb func (tx *Tx) error) error {
+  if m.conn == nil { return cb(&Tx{*m}) }
+  for attempt := 1; ; attempt++ {
+    err := m.transaction(ctx, cb)
+    if err == nil || attempt == MaxTransactionAttempts || !IsRetryableError(err) {
+      return err
+    }
+    delay := time.Duration(attempt) * TransactionRetryDelay
+    delay += time.Duration(rand.Int63n(int64(TransactionRetryDelay)))
+    select {
+    case <-ctx.Done():
+      return err
+    case <-time.After(delay):
+    }
+  }
+}
+
+func (m *Model) transaction(ctx context.Context, cb func (tx *Tx) error) (err error) {
+  /* modl transactions do not take a context: ctx is checked before the
+     transaction begins and again before it is committed. */
+  if err = ctx.Err(); err != nil { return errors.Wrap(err, 0) }
+  mtx, err := m.conn.Begin()
   if err != nil { return errors.Wrap(err, 0) }
-  err = cb()
-  if err != nil {
-    tx.Rollback()
-    return err
+  tx := &Tx{*m}
+  tx.db = mtx.Tx
+  tx.dbMap = mtx
+  tx.conn = nil
+  committed := false
+  defer func () {
+    if !committed { mtx.Rollback() }
+  }()
+  err = cb(tx)
+  if err != nil { return err }
+  if err = ctx.Err(); err != nil { return errors.Wrap(err, 0) }
+  err = mtx.Commit()
+  if err != nil { return errors.Wrap(err, 0) }
+  committed = true
+  return nil
+}
+
+/* Deadlocks (1213) and lock wait timeouts (1205) abort the statement or
+   transaction, which can be attempted again.  Callbacks passed to
+   Transaction that do not return every error they get must return these. */
+func IsRetryableError(err error) bool {
+  for err != nil {
+    if myErr, ok := err.(*mysql.MySQLError); ok {
+      return myErr.Number == 1213 || myErr.Number == 1205
+    }
+    switch e := err.(type) {
+    case *errors.Error:
+      err = e.Err
+    case interface{ Unwrap() error }:
+      err = e.Unwrap()
+    default:
+      return false
+    }
   }
-  err = tx.Commit()
-  if err != nil {
-    return errors.Wrap(err, 0)
+  return false
+}
+
+/*
+  Runs cb in a savepoint of the transaction: if cb returns an error, the
+  changes made by cb are rolled back, and those made before are kept.
+  Returns the error of cb, and an error if the savepoint could not be
+  managed (the transaction must then be rolled back).  Retryable errors
+  are not rolled back to the savepoint, as the whole transaction has to
+  be attempted again.  Savepoints of the same name must not be nested.
+*/
+func (tx *Tx) Savepoint(name string, cb func () error) (cbErr error, err error) {
+  _, err = tx.db.Exec("SAVEPOINT " + name)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  cbErr = cb()
+  if cbErr != nil {
+    if IsRetryableError(cbErr) { return cbErr, nil }
+    _, err = tx.db.Exec("ROLLBACK TO SAVEPOINT " + name)
+    if err != nil { return cbErr, errors.Wrap(err, 0) }
+    return cbErr, nil
   }
-  return nil
+  _, err = tx.db.Exec("RELEASE SAVEPOINT " + name)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return nil, nil
 }
 
 type IRow interface {
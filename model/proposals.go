@@ -0,0 +1,144 @@
+package model
+
+import (
+  "database/sql"
+  "strings"
+  "time"
+  "github.com/go-errors/errors"
+  "github.com/go-sql-driver/mysql"
+)
+
+/*
+  A proposal asks the owners of a target chain (usually the parent of the
+  source chain) to adopt the protocol of a source chain.
+*/
+type ChainProposal struct {
+  Id int64
+  Created_at time.Time
+  Updated_at time.Time
+  Contest_id int64
+  Team_id int64
+  Source_chain_id int64
+  Target_chain_id int64
+  Title string
+  Description string `db:"description_text"`
+  Protocol_hash string
+  Status string /* "open", "accepted", "rejected" */
+  Resolved_at mysql.NullTime
+  Resolved_by sql.NullInt64
+}
+
+type ChainProposalComment struct {
+  Id int64
+  Created_at time.Time
+  Proposal_id int64
+  User_id int64
+  Body string `db:"body_text"`
+}
+
+func (m *Model) CreateChainProposal(userId int64, sourceChainId int64, targetChainId int64, title string, description string) (int64, error) {
+  var err error
+  source, err := m.LoadChain(sourceChainId)
+  if err != nil { return 0, err }
+  target, err := m.LoadChain(targetChainId)
+  if err != nil { return 0, err }
+  if source.Id == target.Id { return 0, errors.New("cannot propose a chain to itself") }
+  if source.Contest_id != target.Contest_id { return 0, errors.New("contest mismatch") }
+  /* Only members of the team owning the source chain can propose it. */
+  team, err := m.LoadUserContestTeam(userId, source.Contest_id)
+  if err != nil { return 0, err }
+  if team == nil || !source.Owner_id.Valid || team.Id != source.Owner_id.Int64 {
+    return 0, errors.New("access denied")
+  }
+  if !IsPublicChainStatus(target.Status_id) &&
+      (!target.Owner_id.Valid || target.Owner_id.Int64 != team.Id) {
+    return 0, errors.New("access denied")
+  }
+  if source.Protocol_hash == "" { return 0, errors.New("source chain has no protocol") }
+  if source.Protocol_hash == target.New_protocol_hash {
+    return 0, errors.New("target chain already uses this protocol")
+  }
+  title = strings.TrimSpace(title)
+  if len(title) == 0 { return 0, errors.New("title is too short") }
+  now := time.Now()
+  proposal := &ChainProposal{
+    Created_at: now,
+    Updated_at: now,
+    Contest_id: source.Contest_id,
+    Team_id: team.Id,
+    Source_chain_id: source.Id,
+    Target_chain_id: target.Id,
+    Title: title,
+    Description: description,
+    Protocol_hash: source.Protocol_hash,
+    Status: "open",
+  }
+  err = m.dbMap.Insert(proposal)
+  if err != nil { return 0, errors.Wrap(err, 0) }
+  return proposal.Id, nil
+}
+
+func (m *Model) LoadChainProposal(proposalId int64) (*ChainProposal, error) {
+  var proposal ChainProposal
+  err := m.dbMap.Get(&proposal, proposalId)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return &proposal, nil
+}
+
+/* Loads the proposals made from or to a chain, most recent first. */
+func (m *Model) LoadChainProposals(chainId int64) ([]ChainProposal, error) {
+  var proposals []ChainProposal
+  err := m.dbMap.Select(&proposals,
+    `SELECT * FROM chain_proposals
+     WHERE source_chain_id = ? OR target_chain_id = ?
+     ORDER BY created_at DESC, id DESC`, chainId, chainId)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return proposals, nil
+}
+
+func (m *Model) LoadChainProposalComments(proposalId int64) ([]ChainProposalComment, error) {
+  var comments []ChainProposalComment
+  err := m.dbMap.Select(&comments,
+    `SELECT * FROM chain_proposal_comments WHERE proposal_id = ? ORDER BY id`, proposalId)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return comments, nil
+}
+
+func (m *Model) AddChainProposalComment(proposalId int64, userId int64, body string) (int64, error) {
+  body = strings.TrimSpace(body)
+  if len(body) == 0 { return 0, errors.New("comment is empty") }
+  comment := &ChainProposalComment{
+    Created_at: time.Now(),
+    Proposal_id: proposalId,
+    User_id: userId,
+    Body: body,
+  }
+  err := m.dbMap.Insert(comment)
+  if err != nil { return 0, errors.Wrap(err, 0) }
+  return comment.Id, nil
+}
+
+/* CanResolveChainProposal tells whether a user can accept or reject a
+   proposal: members of the team owning the target chain can, and admins
+   can for chains without owner. */
+func (m *Model) CanResolveChainProposal(userId int64, target *Chain) (bool, error) {
+  if !target.Owner_id.Valid {
+    return m.IsUserAdmin(userId), nil
+  }
+  return m.IsUserInTeam(userId, target.Owner_id.Int64)
+}
+
+/* Marks an open proposal as accepted or rejected. */
+func (m *Model) ResolveChainProposal(proposalId int64, userId int64, status string) error {
+  if status != "accepted" && status != "rejected" {
+    return errors.Errorf("bad proposal status %s", status)
+  }
+  res, err := m.db.Exec(
+    `UPDATE chain_proposals SET status = ?, resolved_at = NOW(), resolved_by = ?
+     WHERE id = ? AND status = 'open'`, status, userId, proposalId)
+  if err != nil { return errors.Wrap(err, 0) }
+  if n, err := res.RowsAffected(); err != nil || n == 0 {
+    return errors.New("proposal is not open")
+  }
+  return nil
+}
@@ -36,7 +36,9 @@ func TestMain(m *testing.M) {
 
   err = pool.Retry(func() error {
     var err error
-    db, err = sql.Open("mysql", fmt.Sprintf("testing:testing@(localhost:%s)/testing?parseTime=true", resource.GetPort("3306/tcp")))
+    dataSource, err := SerializableDataSource(fmt.Sprintf("testing:testing@(localhost:%s)/testing?parseTime=true", resource.GetPort("3306/tcp")))
+    if err != nil { return err }
+    db, err = sql.Open("mysql", dataSource)
     if err != nil { return err }
     return db.Ping()
   })
@@ -84,7 +86,10 @@ func TestSomething(t *testing.T) {
     lastname: "lastname",
     badges: []string{"badge1"},
   })
-  if err != nil { t.Error(err) }
-  err = model.ViewUser(userId)
-  if err != nil { t.Error(err) }
+  if err != nil { t.Fatal(err) }
+  user, err := model.LoadUser(userId)
+  if err != nil { t.Fatal(err) }
+  if user == nil || user.Username != "username" || user.Firstname != "firstname" {
+    t.Errorf("unexpected user %+v", user)
+  }
 }
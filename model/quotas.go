@@ -0,0 +1,74 @@
+package model
+
+import (
+  "time"
+  "github.com/go-errors/errors"
+)
+
+/* A game that has not ended is considered running if a round was played
+   recently. */
+var RunningGameIdleTimeout = 24 * time.Hour
+
+type TeamUsage struct {
+  Nb_private_chains int64
+  Nb_running_games int64
+  Store_bytes int64
+}
+
+func (m *Model) LoadTeamUsage(teamId int64) (*TeamUsage, error) {
+  var err error
+  var usage TeamUsage
+  err = m.db.QueryRow(
+    `SELECT COUNT(*) FROM chains WHERE owner_id = ? AND status_id = 1`, teamId).Scan(&usage.Nb_private_chains)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  err = m.db.QueryRow(
+    `SELECT COUNT(*) FROM games
+     WHERE owner_id = ? AND current_round < max_nb_rounds AND updated_at > ?`,
+     teamId, time.Now().Add(-RunningGameIdleTimeout)).Scan(&usage.Nb_running_games)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  err = m.db.QueryRow(
+    `SELECT store_bytes FROM teams WHERE id = ?`, teamId).Scan(&usage.Store_bytes)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return &usage, nil
+}
+
+/* CheckTeamQuotas verifies that the team can create the given number of
+   private chains and games without exceeding its contest's quotas.
+   Creation is refused once the team's block store usage reaches its quota.
+   Call it in the transaction that creates the chains and games. */
+func (m *Model) CheckTeamQuotas(teamId int64, newPrivateChains int64, newGames int64) error {
+  /* In a transaction, lock the team's row so that concurrent creations by
+     the team are checked one after the other. */
+  var id int64
+  err := m.db.QueryRow(`SELECT id FROM teams WHERE id = ? FOR UPDATE`, teamId).Scan(&id)
+  if err != nil { return errors.Wrap(err, 0) }
+  team, err := m.LoadTeam(teamId)
+  if err != nil { return err }
+  contest, err := m.LoadContest(team.Contest_id)
+  if err != nil { return err }
+  usage, err := m.LoadTeamUsage(teamId)
+  if err != nil { return err }
+  if newPrivateChains != 0 && contest.Max_private_chains.Valid &&
+      usage.Nb_private_chains + newPrivateChains > contest.Max_private_chains.Int64 {
+    return errors.Errorf("quota exceeded: at most %d private chains",
+      contest.Max_private_chains.Int64)
+  }
+  if newGames != 0 && contest.Max_running_games.Valid &&
+      usage.Nb_running_games + newGames > contest.Max_running_games.Int64 {
+    return errors.Errorf("quota exceeded: at most %d running games",
+      contest.Max_running_games.Int64)
+  }
+  if contest.Max_store_bytes.Valid && usage.Store_bytes >= contest.Max_store_bytes.Int64 {
+    return errors.Errorf("quota exceeded: at most %d bytes of blocks",
+      contest.Max_store_bytes.Int64)
+  }
+  return nil
+}
+
+/* Charges the size of newly created blocks to a team. */
+func (m *Model) AddTeamStoreBytes(teamId int64, size int64) error {
+  _, err := m.db.Exec(
+    `UPDATE teams SET store_bytes = store_bytes + ? WHERE id = ?`, size, teamId)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
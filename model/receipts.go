@@ -0,0 +1,41 @@
+package model
+
+import (
+  "database/sql"
+  "time"
+  "github.com/go-errors/errors"
+)
+
+/* Receipts signed by the backend, see routes/receipts.go. */
+type GameReceipt struct {
+  Id int64
+  Created_at time.Time
+  Game_key string
+  Round uint64
+  Kind string /* "commands", "block" */
+  Team_id sql.NullInt64 /* team that entered the commands */
+  Receipt string
+}
+
+func (m *Model) CreateGameReceipt(gameKey string, round uint64, kind string, teamId int64, receipt []byte) error {
+  err := m.dbMap.Insert(&GameReceipt{
+    Created_at: time.Now(),
+    Game_key: gameKey,
+    Round: round,
+    Kind: kind,
+    Team_id: sql.NullInt64{Int64: teamId, Valid: teamId != 0},
+    Receipt: string(receipt),
+  })
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+/* Loads the receipts of a game from the given round on, in order. */
+func (m *Model) LoadGameReceipts(gameKey string, fromRound uint64, limit int) ([]GameReceipt, error) {
+  var receipts []GameReceipt
+  err := m.dbMap.Select(&receipts,
+    `SELECT * FROM game_receipts WHERE game_key = ? AND round >= ?
+     ORDER BY round, id LIMIT ?`, gameKey, fromRound, limit)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return receipts, nil
+}
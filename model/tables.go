@@ -6,30 +6,44 @@ import (
 
 type Tables struct {
   chains *modl.TableMap
+  chainProposals *modl.TableMap
+  chainProposalComments *modl.TableMap
   chainRevisions *modl.TableMap
   contests *modl.TableMap
+  gameActions *modl.TableMap
+  gameReceipts *modl.TableMap
   games *modl.TableMap
   gamePlayers *modl.TableMap
   tasks *modl.TableMap
   taskResources *modl.TableMap
+  teamKeys *modl.TableMap
   teamMembers *modl.TableMap
   teams *modl.TableMap
+  teamWebhooks *modl.TableMap
   users *modl.TableMap
+  webhookDeliveries *modl.TableMap
 
   //chainStatuses *modl.TableMap
 }
 
 func (t *Tables) Map(m *modl.DbMap) {
   t.chains = m.AddTableWithName(Chain{}, "chains").SetKeys(true, "Id")
+  t.chainProposals = m.AddTableWithName(ChainProposal{}, "chain_proposals").SetKeys(true, "Id")
+  t.chainProposalComments = m.AddTableWithName(ChainProposalComment{}, "chain_proposal_comments").SetKeys(true, "Id")
   t.chainRevisions = m.AddTableWithName(ChainRevision{}, "chain_revisions").SetKeys(true, "Id")
   t.contests = m.AddTableWithName(Contest{}, "contests").SetKeys(true, "Id")
+  t.gameActions = m.AddTableWithName(GameAction{}, "game_actions").SetKeys(true, "Id")
+  t.gameReceipts = m.AddTableWithName(GameReceipt{}, "game_receipts").SetKeys(true, "Id")
   t.games = m.AddTableWithName(Game{}, "games").SetKeys(true, "Id")
   t.gamePlayers = m.AddTableWithName(GamePlayer{}, "game_players").SetKeys(true, "Game_id", "Rank")
   t.users = m.AddTableWithName(User{}, "users").SetKeys(true, "Id")
   t.taskResources = m.AddTableWithName(TaskResource{}, "task_resources").SetKeys(true, "Id")
   t.tasks = m.AddTableWithName(Task{}, "tasks").SetKeys(true, "Id")
+  t.teamKeys = m.AddTableWithName(TeamKey{}, "team_keys").SetKeys(true, "Id")
   t.teamMembers = m.AddTableWithName(TeamMember{}, "team_members").SetKeys(true, "Team_id", "User_id")
   t.teams = m.AddTableWithName(Team{}, "teams").SetKeys(true, "Id")
+  t.teamWebhooks = m.AddTableWithName(TeamWebhook{}, "team_webhooks").SetKeys(true, "Id")
+  t.webhookDeliveries = m.AddTableWithName(WebhookDelivery{}, "webhook_deliveries").SetKeys(true, "Id")
   t.users = m.AddTableWithName(User{}, "users").SetKeys(true, "Id")
   //t.chainStatuses = m.AddTableWithName(ChainStatus{}, "chain_statuses").SetKeys(true, "Id")
 }
@@ -0,0 +1,185 @@
+package model
+
+import (
+  "database/sql"
+  "time"
+  "github.com/go-errors/errors"
+  "github.com/go-sql-driver/mysql"
+  "tezos-contests.izibi.com/backend/signing"
+)
+
+/* Maximum number of unrevoked keys of a team. */
+const MaxTeamKeys = 10
+
+/*
+  A team can have several keys, any of which can sign its requests until
+  it expires or is revoked.  The team's Public_key is its primary key,
+  which identifies the team's bots in game pings.
+*/
+type TeamKey struct {
+  Id int64
+  Created_at time.Time
+  Team_id int64
+  Public_key string
+  Label string
+  Expires_at mysql.NullTime
+  Revoked_at mysql.NullTime
+}
+
+type GameAction struct {
+  Id int64
+  Created_at time.Time
+  Game_key string
+  Team_id int64
+  Team_key_id int64
+  Action string
+}
+
+func (key *TeamKey) IsActive(now time.Time) bool {
+  if key.Revoked_at.Valid { return false }
+  if key.Expires_at.Valid && !now.Before(key.Expires_at.Time) { return false }
+  return true
+}
+
+/* Finds the active key with the given public key (without the '@' prefix)
+   of a team that is not deleted.  Returns nil if there is none. */
+func (m *Model) FindTeamKey(publicKey string) (*TeamKey, error) {
+  var key TeamKey
+  err := m.dbMap.SelectOne(&key,
+    `SELECT k.* FROM team_keys k INNER JOIN teams t ON t.id = k.team_id
+     WHERE k.public_key = ? AND k.revoked_at IS NULL
+       AND (k.expires_at IS NULL OR k.expires_at > NOW())
+       AND t.deleted_at IS NULL`, publicKey)
+  if err == sql.ErrNoRows { return nil, nil }
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return &key, nil
+}
+
+func (m *Model) LoadTeamKeys(teamId int64) ([]TeamKey, error) {
+  var keys []TeamKey
+  err := m.dbMap.Select(&keys,
+    `SELECT * FROM team_keys WHERE team_id = ? ORDER BY id`, teamId)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return keys, nil
+}
+
+func (m *Model) LoadTeamKey(keyId int64) (*TeamKey, error) {
+  var key TeamKey
+  err := m.dbMap.Get(&key, keyId)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return &key, nil
+}
+
+/* Adds a key to a team.  The team's first key becomes its primary key.
+   Call it in a transaction, so that both are saved or neither is. */
+func (m *Model) AddTeamKey(userId int64, teamId int64, publicKey string, label string, expiresAt *time.Time) (*TeamKey, error) {
+  var err error
+  isMember, err := m.IsUserInTeam(userId, teamId)
+  if err != nil { return nil, err }
+  if !isMember { return nil, errors.Errorf("forbidden") }
+  team, err := m.LoadTeam(teamId)
+  if err != nil { return nil, err }
+  if team.Is_locked { return nil, errors.Errorf("team is locked") }
+  return m.addTeamKey(team, publicKey, label, expiresAt)
+}
+
+func (m *Model) addTeamKey(team *Team, publicKey string, label string, expiresAt *time.Time) (*TeamKey, error) {
+  var err error
+  err = signing.CheckPublicKey(publicKey)
+  if err != nil { return nil, err }
+  now := time.Now()
+  if expiresAt != nil && !expiresAt.After(now) {
+    return nil, errors.New("expiry date is in the past")
+  }
+  var count int
+  err = m.db.QueryRow(
+    `SELECT COUNT(*) FROM team_keys WHERE team_id = ? AND revoked_at IS NULL`,
+    team.Id).Scan(&count)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  if count >= MaxTeamKeys { return nil, errors.New("too many keys") }
+  var inUse bool
+  err = m.db.QueryRow(
+    `SELECT EXISTS(SELECT 1 FROM team_keys WHERE public_key = ?)`, publicKey).Scan(&inUse)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  if inUse { return nil, errors.New("key is already in use") }
+  key := &TeamKey{
+    Created_at: now,
+    Team_id: team.Id,
+    Public_key: publicKey,
+    Label: label,
+  }
+  if expiresAt != nil {
+    key.Expires_at = mysql.NullTime{Time: *expiresAt, Valid: true}
+  }
+  err = m.dbMap.Insert(key)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  if team.Public_key == "" {
+    err = m.setTeamPrimaryKey(team, publicKey)
+    if err != nil { return nil, err }
+  }
+  return key, nil
+}
+
+/* Revokes a key.  If it was the team's primary key, the most recent
+   active key becomes primary.  Call it in a transaction. */
+func (m *Model) RevokeTeamKey(userId int64, keyId int64) (*TeamKey, error) {
+  var err error
+  key, err := m.LoadTeamKey(keyId)
+  if err != nil { return nil, err }
+  isMember, err := m.IsUserInTeam(userId, key.Team_id)
+  if err != nil { return nil, err }
+  if !isMember { return nil, errors.Errorf("forbidden") }
+  if key.Revoked_at.Valid { return key, nil }
+  now := time.Now()
+  _, err = m.db.Exec(
+    `UPDATE team_keys SET revoked_at = ? WHERE id = ?`, now, keyId)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  key.Revoked_at = mysql.NullTime{Time: now, Valid: true}
+  team, err := m.LoadTeam(key.Team_id)
+  if err != nil { return nil, err }
+  if team.Public_key == key.Public_key {
+    var next string
+    err = m.db.QueryRow(
+      `SELECT public_key FROM team_keys
+       WHERE team_id = ? AND revoked_at IS NULL
+         AND (expires_at IS NULL OR expires_at > NOW())
+       ORDER BY id DESC LIMIT 1`, key.Team_id).Scan(&next)
+    if err != nil && err != sql.ErrNoRows { return nil, errors.Wrap(err, 0) }
+    err = m.setTeamPrimaryKey(team, next)
+    if err != nil { return nil, err }
+  }
+  return key, nil
+}
+
+func (m *Model) setTeamPrimaryKey(team *Team, publicKey string) error {
+  _, err := m.db.Exec(
+    `UPDATE teams SET public_key = ?, updated_at = NOW() WHERE id = ?`,
+    publicKey, team.Id)
+  if err != nil { return errors.Wrap(err, 0) }
+  team.Public_key = publicKey
+  return nil
+}
+
+/* Records the key used for a game action, in the action's transaction once
+   the action has succeeded. */
+func (m *Model) RecordGameAction(gameKey string, key *TeamKey, action string) error {
+  err := m.dbMap.Insert(&GameAction{
+    Created_at: time.Now(),
+    Game_key: gameKey,
+    Team_id: key.Team_id,
+    Team_key_id: key.Id,
+    Action: action,
+  })
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+/* Loads the most recent game actions of a team, most recent first. */
+func (m *Model) LoadTeamGameActions(teamId int64, limit int) ([]GameAction, error) {
+  var actions []GameAction
+  err := m.dbMap.Select(&actions,
+    `SELECT * FROM game_actions WHERE team_id = ? ORDER BY id DESC LIMIT ?`,
+    teamId, limit)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return actions, nil
+}
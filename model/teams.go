@@ -21,6 +21,7 @@ type Team struct {
   Is_locked bool
   Name string
   Public_key string
+  Store_bytes int64
 }
 
 type TeamMember struct {
@@ -224,14 +225,24 @@ func (m *Model) UpdateTeam(teamId int64, userId int64, arg UpdateTeamArg) (*Team
     save = true
     team.Is_open = *arg.IsOpen
   }
-  if arg.PublicKey != nil && *arg.PublicKey != team.Public_key {
-    save = true
-    team.Public_key = *arg.PublicKey
-  }
   if save {
     team.Updated_at = time.Now()
     m.dbMap.Update(team)
   }
+  /* A new public key becomes the team's primary key; the previous keys
+     remain valid until they are revoked. */
+  if arg.PublicKey != nil && *arg.PublicKey != team.Public_key {
+    key, err := m.FindTeamKey(*arg.PublicKey)
+    if err != nil { return nil, err }
+    if key == nil {
+      _, err = m.addTeamKey(team, *arg.PublicKey, "primary", nil)
+      if err != nil { return nil, err }
+    } else if key.Team_id != teamId {
+      return nil, errors.New("key is already in use")
+    }
+    err = m.setTeamPrimaryKey(team, *arg.PublicKey)
+    if err != nil { return nil, err }
+  }
 
   return team, nil
 }
@@ -258,13 +269,12 @@ func (m *Model) RenewTeamAccessCode(teamId int64, userId int64) (*Team, error) {
   return team, nil
 }
 
+/* Returns the id of the team having the given active key, or 0. */
 func (m *Model) FindTeamIdByKey(publicKey string) (int64, error) {
-  row := m.db.QueryRow(`SELECT id FROM teams WHERE public_key = ? AND deleted_at IS NULL`, publicKey)
-  var id int64
-  err := row.Scan(&id)
-  if err == sql.ErrNoRows { return 0, nil }
+  key, err := m.FindTeamKey(publicKey)
   if err != nil { return 0, err }
-  return id, nil
+  if key == nil { return 0, nil }
+  return key.Team_id, nil
 }
 
 func (m *Model) isUserNotInAnyTeam(userId int64, contestId int64) (bool, error) {
@@ -0,0 +1,232 @@
+package model
+
+import (
+  "crypto/rand"
+  "database/sql"
+  "encoding/hex"
+  "net"
+  "net/url"
+  "strings"
+  "time"
+  "github.com/go-errors/errors"
+  "github.com/go-sql-driver/mysql"
+  "github.com/jmoiron/sqlx"
+  "tezos-contests.izibi.com/backend/utils"
+)
+
+/* Maximum number of webhooks a team can register. */
+const MaxTeamWebhooks = 10
+
+/* A delivery is marked failed after this number of attempts. */
+const MaxWebhookAttempts = 8
+
+type TeamWebhook struct {
+  Id int64
+  Created_at time.Time
+  Updated_at time.Time
+  Team_id int64
+  Url string
+  Secret string
+  Event_types string /* comma-separated, empty for all event types */
+  Is_active bool
+}
+
+type WebhookDelivery struct {
+  Id int64
+  Created_at time.Time
+  Webhook_id int64
+  Event_type string
+  Payload string
+  Status string /* "pending", "delivered", "failed" */
+  Nb_attempts int
+  Next_attempt_at mysql.NullTime
+  Last_attempt_at mysql.NullTime
+  Last_status_code sql.NullInt64
+  Last_error string
+}
+
+func (hook *TeamWebhook) Accepts(eventType string) bool {
+  if hook.Event_types == "" { return true }
+  for _, typ := range strings.Split(hook.Event_types, ",") {
+    if typ == eventType { return true }
+  }
+  return false
+}
+
+func (m *Model) CreateTeamWebhook(userId int64, teamId int64, hookUrl string, eventTypes []string) (*TeamWebhook, error) {
+  var err error
+  isMember, err := m.IsUserInTeam(userId, teamId)
+  if err != nil { return nil, err }
+  if !isMember { return nil, errors.Errorf("forbidden") }
+  parsed, err := url.Parse(hookUrl)
+  if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+    return nil, errors.New("bad webhook url")
+  }
+  /* Addresses are checked again when deliveries are sent, as a name can
+     resolve to a different address. */
+  host := parsed.Hostname()
+  if ip := net.ParseIP(host); (ip != nil && !utils.IsPublicIP(ip)) || strings.EqualFold(host, "localhost") {
+    return nil, errors.New("bad webhook url")
+  }
+  for _, typ := range eventTypes {
+    if typ == "" || strings.Contains(typ, ",") {
+      return nil, errors.Errorf("bad event type %q", typ)
+    }
+  }
+  var count int
+  err = m.db.QueryRow(`SELECT COUNT(*) FROM team_webhooks WHERE team_id = ?`, teamId).Scan(&count)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  if count >= MaxTeamWebhooks { return nil, errors.New("too many webhooks") }
+  secret := make([]byte, 32)
+  _, err = rand.Read(secret)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  now := time.Now()
+  hook := &TeamWebhook{
+    Created_at: now,
+    Updated_at: now,
+    Team_id: teamId,
+    Url: hookUrl,
+    Secret: hex.EncodeToString(secret),
+    Event_types: strings.Join(eventTypes, ","),
+    Is_active: true,
+  }
+  err = m.dbMap.Insert(hook)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return hook, nil
+}
+
+func (m *Model) LoadTeamWebhooks(teamId int64) ([]TeamWebhook, error) {
+  var hooks []TeamWebhook
+  err := m.dbMap.Select(&hooks,
+    `SELECT * FROM team_webhooks WHERE team_id = ? ORDER BY id`, teamId)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return hooks, nil
+}
+
+func (m *Model) LoadTeamWebhook(webhookId int64) (*TeamWebhook, error) {
+  var hook TeamWebhook
+  err := m.dbMap.Get(&hook, webhookId)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return &hook, nil
+}
+
+func (m *Model) DeleteTeamWebhook(userId int64, webhookId int64) error {
+  hook, err := m.LoadTeamWebhook(webhookId)
+  if err != nil { return err }
+  isMember, err := m.IsUserInTeam(userId, hook.Team_id)
+  if err != nil { return err }
+  if !isMember { return errors.Errorf("forbidden") }
+  _, err = m.db.Exec(`DELETE FROM team_webhooks WHERE id = ?`, webhookId)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+/* Loads the active webhooks of the given teams. */
+func (m *Model) LoadActiveWebhooks(teamIds []int64) ([]TeamWebhook, error) {
+  var hooks []TeamWebhook
+  if len(teamIds) == 0 { return hooks, nil }
+  query, args, err := sqlx.In(
+    `SELECT * FROM team_webhooks WHERE is_active AND team_id IN (?)`, teamIds)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  err = m.dbMap.Select(&hooks, query, args...)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return hooks, nil
+}
+
+func (m *Model) CreateWebhookDelivery(webhookId int64, eventType string, payload string) error {
+  now := time.Now()
+  delivery := &WebhookDelivery{
+    Created_at: now,
+    Webhook_id: webhookId,
+    Event_type: eventType,
+    Payload: payload,
+    Status: "pending",
+    Next_attempt_at: mysql.NullTime{Time: now, Valid: true},
+  }
+  err := m.dbMap.Insert(delivery)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+/*
+  Claims up to limit pending deliveries that are due, by pushing back their
+  next attempt time by lease.  A delivery can thus be claimed by a single
+  server instance at a time.
+*/
+func (m *Model) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error) {
+  var err error
+  var due []WebhookDelivery
+  err = m.dbMap.Select(&due,
+    `SELECT * FROM webhook_deliveries
+     WHERE status = 'pending' AND next_attempt_at <= NOW()
+     ORDER BY next_attempt_at LIMIT ?`, limit)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  var claimed []WebhookDelivery
+  leaseEnd := time.Now().Add(lease)
+  for _, delivery := range due {
+    res, err := m.db.Exec(
+      `UPDATE webhook_deliveries SET next_attempt_at = ?
+       WHERE id = ? AND status = 'pending' AND next_attempt_at = ?`,
+      leaseEnd, delivery.Id, delivery.Next_attempt_at)
+    if err != nil { return nil, errors.Wrap(err, 0) }
+    if n, err := res.RowsAffected(); err == nil && n == 1 {
+      claimed = append(claimed, delivery)
+    }
+  }
+  return claimed, nil
+}
+
+/* Records the outcome of a delivery attempt.  Failed attempts are retried
+   after retryDelay, until MaxWebhookAttempts is reached. */
+func (m *Model) RecordWebhookAttempt(delivery *WebhookDelivery, statusCode int, attemptErr string, retryDelay time.Duration) error {
+  now := time.Now()
+  delivery.Nb_attempts += 1
+  delivery.Last_attempt_at = mysql.NullTime{Time: now, Valid: true}
+  delivery.Last_status_code = sql.NullInt64{Int64: int64(statusCode), Valid: statusCode != 0}
+  delivery.Last_error = attemptErr
+  delivery.Next_attempt_at = mysql.NullTime{}
+  if attemptErr == "" {
+    delivery.Status = "delivered"
+  } else if delivery.Nb_attempts >= MaxWebhookAttempts {
+    delivery.Status = "failed"
+  } else {
+    delivery.Status = "pending"
+    delivery.Next_attempt_at = mysql.NullTime{Time: now.Add(retryDelay), Valid: true}
+  }
+  _, err := m.dbMap.Update(delivery)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+/* Marks a delivery that will not be attempted (as its webhook was
+   deactivated) as failed. */
+func (m *Model) CancelWebhookDelivery(delivery *WebhookDelivery, reason string) error {
+  delivery.Status = "failed"
+  delivery.Last_error = reason
+  delivery.Next_attempt_at = mysql.NullTime{}
+  _, err := m.dbMap.Update(delivery)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+/* Loads the most recent deliveries of a webhook, most recent first. */
+func (m *Model) LoadWebhookDeliveries(webhookId int64, limit int) ([]WebhookDelivery, error) {
+  var deliveries []WebhookDelivery
+  err := m.dbMap.Select(&deliveries,
+    `SELECT * FROM webhook_deliveries WHERE webhook_id = ?
+     ORDER BY id DESC LIMIT ?`, webhookId, limit)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return deliveries, nil
+}
+
+/* Loads the ids of the teams owning or playing in a game. */
+func (m *Model) LoadGameTeamIds(gameKey string) ([]int64, error) {
+  var teamIds []int64
+  err := m.db.Select(&teamIds,
+    `SELECT owner_id FROM games WHERE game_key = ?
+     UNION
+     SELECT gp.team_id FROM game_players gp, games g
+     WHERE g.game_key = ? AND gp.game_id = g.id`, gameKey, gameKey)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return teamIds, nil
+}
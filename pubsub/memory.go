@@ -0,0 +1,314 @@
+
+package pubsub
+
+import (
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+  "github.com/go-errors/errors"
+)
+
+/* Number of messages buffered for each subscription.  The in-memory
+   backend drops messages published to a subscription whose buffer is full;
+   the events service only uses them as wake-up notifications. */
+var SubscriptionBufferSize = 100
+
+type memoryBackend struct {
+  mutex sync.Mutex
+  subs map[string]map[*memorySubscription]bool /* channel -> subscriptions */
+  values map[string]*memoryValue
+  lastTime int64 /* of the last log entry id, in milliseconds */
+  lastSeq int64
+}
+
+type memoryValue struct {
+  str string
+  set map[string]bool
+  log []Entry
+  expires time.Time /* zero if the value does not expire */
+}
+
+type memorySubscription struct {
+  backend *memoryBackend
+  channels map[string]bool
+  channel chan *Message
+  closed bool
+}
+
+func NewMemory() Backend {
+  return &memoryBackend{
+    subs: make(map[string]map[*memorySubscription]bool),
+    values: make(map[string]*memoryValue),
+  }
+}
+
+func (b *memoryBackend) Publish(channel string, payload string) error {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  for sub := range b.subs[channel] {
+    select {
+    case sub.channel <- &Message{Channel: channel, Payload: payload}:
+    default:
+    }
+  }
+  return nil
+}
+
+func (b *memoryBackend) Subscribe(channels ...string) Subscription {
+  sub := &memorySubscription{
+    backend: b,
+    channels: make(map[string]bool),
+    channel: make(chan *Message, SubscriptionBufferSize),
+  }
+  _ = sub.Subscribe(channels...)
+  return sub
+}
+
+func (sub *memorySubscription) Subscribe(channels ...string) error {
+  b := sub.backend
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if sub.closed { return errors.New("subscription is closed") }
+  for _, channel := range channels {
+    subs, ok := b.subs[channel]
+    if !ok {
+      subs = make(map[*memorySubscription]bool)
+      b.subs[channel] = subs
+    }
+    subs[sub] = true
+    sub.channels[channel] = true
+  }
+  return nil
+}
+
+func (sub *memorySubscription) Unsubscribe(channels ...string) error {
+  b := sub.backend
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  sub.unsubscribe(channels)
+  return nil
+}
+
+func (sub *memorySubscription) unsubscribe(channels []string) {
+  b := sub.backend
+  for _, channel := range channels {
+    delete(sub.channels, channel)
+    if subs, ok := b.subs[channel]; ok {
+      delete(subs, sub)
+      if len(subs) == 0 {
+        delete(b.subs, channel)
+      }
+    }
+  }
+}
+
+func (sub *memorySubscription) Channel() <-chan *Message {
+  return sub.channel
+}
+
+func (sub *memorySubscription) Close() error {
+  b := sub.backend
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  if sub.closed { return nil }
+  var channels []string
+  for channel := range sub.channels {
+    channels = append(channels, channel)
+  }
+  sub.unsubscribe(channels)
+  sub.closed = true
+  close(sub.channel)
+  return nil
+}
+
+func (b *memoryBackend) Close() error {
+  return nil
+}
+
+/* Returns the live value for a key, or nil.  The mutex must be held. */
+func (b *memoryBackend) get(key string) *memoryValue {
+  val, ok := b.values[key]
+  if !ok { return nil }
+  if !val.expires.IsZero() && time.Now().After(val.expires) {
+    delete(b.values, key)
+    return nil
+  }
+  return val
+}
+
+/* Returns the value for a key, creating it if needed. */
+func (b *memoryBackend) getOrCreate(key string) *memoryValue {
+  val := b.get(key)
+  if val == nil {
+    val = &memoryValue{}
+    b.values[key] = val
+  }
+  return val
+}
+
+func (b *memoryBackend) Get(key string) (string, error) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.get(key)
+  if val == nil { return "", ErrNil }
+  return val.str, nil
+}
+
+func (b *memoryBackend) Set(key string, value string, expiry time.Duration) error {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := &memoryValue{str: value}
+  if expiry != 0 {
+    val.expires = time.Now().Add(expiry)
+  }
+  b.values[key] = val
+  return nil
+}
+
+func (b *memoryBackend) GetSet(key string, value string) (string, error) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.get(key)
+  b.values[key] = &memoryValue{str: value}
+  if val == nil { return "", ErrNil }
+  return val.str, nil
+}
+
+func (b *memoryBackend) Del(keys ...string) error {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  for _, key := range keys {
+    delete(b.values, key)
+  }
+  return nil
+}
+
+func (b *memoryBackend) Expire(key string, expiry time.Duration) error {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.get(key)
+  if val != nil {
+    val.expires = time.Now().Add(expiry)
+  }
+  return nil
+}
+
+func (b *memoryBackend) Incr(key string, expiry time.Duration) (int64, error) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.get(key)
+  var n int64
+  if val == nil {
+    val = &memoryValue{expires: time.Now().Add(expiry)}
+    b.values[key] = val
+  } else if val.str != "" {
+    var err error
+    n, err = strconv.ParseInt(val.str, 10, 64)
+    if err != nil { return 0, errors.New("value is not an integer") }
+  }
+  n++
+  val.str = strconv.FormatInt(n, 10)
+  return n, nil
+}
+
+func (b *memoryBackend) SAdd(key string, members ...string) error {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.getOrCreate(key)
+  if val.set == nil {
+    val.set = make(map[string]bool)
+  }
+  for _, member := range members {
+    val.set[member] = true
+  }
+  return nil
+}
+
+func (b *memoryBackend) SRem(key string, members ...string) error {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.get(key)
+  if val == nil { return nil }
+  for _, member := range members {
+    delete(val.set, member)
+  }
+  return nil
+}
+
+func (b *memoryBackend) SMembers(key string) ([]string, error) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.get(key)
+  if val == nil { return nil, nil }
+  var members []string
+  for member := range val.set {
+    members = append(members, member)
+  }
+  sort.Strings(members)
+  return members, nil
+}
+
+/* Log entry ids follow the redis format "<milliseconds>-<sequence>". */
+func (b *memoryBackend) XAdd(key string, maxLen int64, values map[string]string) (string, error) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  now := time.Now().UnixNano() / int64(time.Millisecond)
+  if now > b.lastTime {
+    b.lastTime = now
+    b.lastSeq = 0
+  } else {
+    b.lastSeq++
+  }
+  id := fmt.Sprintf("%d-%d", b.lastTime, b.lastSeq)
+  copied := make(map[string]string, len(values))
+  for k, v := range values {
+    copied[k] = v
+  }
+  val := b.getOrCreate(key)
+  val.log = append(val.log, Entry{Id: id, Values: copied})
+  if maxLen > 0 && int64(len(val.log)) > maxLen {
+    val.log = append([]Entry(nil), val.log[int64(len(val.log)) - maxLen:]...)
+  }
+  return id, nil
+}
+
+func (b *memoryBackend) XRead(key string, after string, count int64) ([]Entry, error) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.get(key)
+  if val == nil { return nil, nil }
+  afterTime, afterSeq, err := parseEntryId(after)
+  if err != nil { return nil, err }
+  i := sort.Search(len(val.log), func (i int) bool {
+    t, s, _ := parseEntryId(val.log[i].Id)
+    return t > afterTime || (t == afterTime && s > afterSeq)
+  })
+  entries := val.log[i:]
+  if count > 0 && int64(len(entries)) > count {
+    entries = entries[:count]
+  }
+  return append([]Entry(nil), entries...), nil
+}
+
+func (b *memoryBackend) XTail(key string) (string, error) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  val := b.get(key)
+  if val == nil || len(val.log) == 0 { return "0-0", nil }
+  return val.log[len(val.log) - 1].Id, nil
+}
+
+func parseEntryId(id string) (int64, int64, error) {
+  parts := strings.SplitN(id, "-", 2)
+  t, err := strconv.ParseInt(parts[0], 10, 64)
+  if err != nil { return 0, 0, errors.Errorf("bad entry id %s", id) }
+  var s int64
+  if len(parts) == 2 {
+    s, err = strconv.ParseInt(parts[1], 10, 64)
+    if err != nil { return 0, 0, errors.Errorf("bad entry id %s", id) }
+  }
+  return t, s, nil
+}
@@ -0,0 +1,69 @@
+/*
+  Publish/subscribe and the small key-value cache used by the events and
+  blocks services.
+
+  The redis implementation is required when several backend instances
+  share streams and caches; the in-memory implementation serves
+  single-node deployments and tests.
+*/
+
+package pubsub
+
+import (
+  "time"
+  "github.com/go-errors/errors"
+)
+
+/* Returned by Get and GetSet when the key does not exist. */
+var ErrNil = errors.New("no such key")
+
+type Message struct {
+  Channel string
+  Payload string
+}
+
+type Subscription interface {
+  Subscribe(channels ...string) error
+  Unsubscribe(channels ...string) error
+  /* Messages are received on this channel, which is closed (yielding nil)
+     when the subscription is closed or the connection is lost. */
+  Channel() <-chan *Message
+  Close() error
+}
+
+type PubSub interface {
+  Publish(channel string, payload string) error
+  Subscribe(channels ...string) Subscription
+}
+
+/* An entry in an append-only log (a redis stream). */
+type Entry struct {
+  Id string
+  Values map[string]string
+}
+
+type Store interface {
+  Get(key string) (string, error)
+  Set(key string, value string, expiry time.Duration) error
+  GetSet(key string, value string) (string, error)
+  Del(keys ...string) error
+  Expire(key string, expiry time.Duration) error
+  /* Increments a counter, which expires after expiry once created. */
+  Incr(key string, expiry time.Duration) (int64, error)
+  SAdd(key string, members ...string) error
+  SRem(key string, members ...string) error
+  SMembers(key string) ([]string, error)
+  /* Appends an entry to a log, keeping approximately maxLen entries. */
+  XAdd(key string, maxLen int64, values map[string]string) (string, error)
+  /* Reads up to count entries following the entry with the given id. */
+  XRead(key string, after string, count int64) ([]Entry, error)
+  /* Returns the id of the last entry in a log, or "0-0" if it is empty. */
+  XTail(key string) (string, error)
+}
+
+type Backend interface {
+  PubSub
+  Store
+  /* Releases the connection, on shutdown. */
+  Close() error
+}
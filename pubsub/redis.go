@@ -0,0 +1,193 @@
+
+package pubsub
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "github.com/go-errors/errors"
+  "github.com/go-redis/redis"
+)
+
+type redisBackend struct {
+  rc *redis.Client
+}
+
+type redisSubscription struct {
+  pubSub *redis.PubSub
+  channel chan *Message
+  done chan struct{}
+  closeOnce sync.Once
+}
+
+func NewRedis(rc *redis.Client) Backend {
+  return &redisBackend{rc}
+}
+
+func (b *redisBackend) Publish(channel string, payload string) error {
+  err := b.rc.Publish(channel, payload).Err()
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (b *redisBackend) Subscribe(channels ...string) Subscription {
+  sub := &redisSubscription{
+    pubSub: b.rc.Subscribe(channels...),
+    channel: make(chan *Message, SubscriptionBufferSize),
+    done: make(chan struct{}),
+  }
+  go func () {
+    defer close(sub.channel)
+    for msg := range sub.pubSub.Channel() {
+      select {
+      case sub.channel <- &Message{Channel: msg.Channel, Payload: msg.Payload}:
+      case <-sub.done:
+        return
+      }
+    }
+  }()
+  return sub
+}
+
+func (sub *redisSubscription) Subscribe(channels ...string) error {
+  err := sub.pubSub.Subscribe(channels...)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (sub *redisSubscription) Unsubscribe(channels ...string) error {
+  err := sub.pubSub.Unsubscribe(channels...)
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (sub *redisSubscription) Channel() <-chan *Message {
+  return sub.channel
+}
+
+func (sub *redisSubscription) Close() error {
+  var err error
+  sub.closeOnce.Do(func () {
+    close(sub.done)
+    err = sub.pubSub.Close()
+  })
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (b *redisBackend) Close() error {
+  err := b.rc.Close()
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (b *redisBackend) Get(key string) (string, error) {
+  val, err := b.rc.Get(key).Result()
+  if err == redis.Nil { return "", ErrNil }
+  if err != nil { return "", errors.Wrap(err, 0) }
+  return val, nil
+}
+
+func (b *redisBackend) Set(key string, value string, expiry time.Duration) error {
+  err := b.rc.Set(key, value, expiry).Err()
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (b *redisBackend) GetSet(key string, value string) (string, error) {
+  val, err := b.rc.GetSet(key, value).Result()
+  if err == redis.Nil { return "", ErrNil }
+  if err != nil { return "", errors.Wrap(err, 0) }
+  return val, nil
+}
+
+func (b *redisBackend) Del(keys ...string) error {
+  err := b.rc.Del(keys...).Err()
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (b *redisBackend) Expire(key string, expiry time.Duration) error {
+  err := b.rc.Expire(key, expiry).Err()
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (b *redisBackend) Incr(key string, expiry time.Duration) (int64, error) {
+  n, err := b.rc.Incr(key).Result()
+  if err != nil { return 0, errors.Wrap(err, 0) }
+  if n == 1 {
+    err = b.rc.Expire(key, expiry).Err()
+    if err != nil { return 0, errors.Wrap(err, 0) }
+  }
+  return n, nil
+}
+
+func (b *redisBackend) SAdd(key string, members ...string) error {
+  err := b.rc.SAdd(key, stringsToAnys(members)...).Err()
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (b *redisBackend) SRem(key string, members ...string) error {
+  err := b.rc.SRem(key, stringsToAnys(members)...).Err()
+  if err != nil { return errors.Wrap(err, 0) }
+  return nil
+}
+
+func (b *redisBackend) SMembers(key string) ([]string, error) {
+  members, err := b.rc.SMembers(key).Result()
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  return members, nil
+}
+
+func (b *redisBackend) XAdd(key string, maxLen int64, values map[string]string) (string, error) {
+  fields := make(map[string]interface{}, len(values))
+  for k, v := range values {
+    fields[k] = v
+  }
+  id, err := b.rc.XAdd(&redis.XAddArgs{
+    Stream: key,
+    MaxLenApprox: maxLen,
+    ID: "*",
+    Values: fields,
+  }).Result()
+  if err != nil { return "", errors.Wrap(err, 0) }
+  return id, nil
+}
+
+func (b *redisBackend) XRead(key string, after string, count int64) ([]Entry, error) {
+  res, err := b.rc.XRead(&redis.XReadArgs{
+    Streams: []string{key, after},
+    Count: count,
+    Block: -1,
+  }).Result()
+  if err == redis.Nil { return nil, nil }
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  var entries []Entry
+  for _, stream := range res {
+    for _, msg := range stream.Messages {
+      values := make(map[string]string, len(msg.Values))
+      for k, v := range msg.Values {
+        values[k] = fmt.Sprint(v)
+      }
+      entries = append(entries, Entry{Id: msg.ID, Values: values})
+    }
+  }
+  return entries, nil
+}
+
+func (b *redisBackend) XTail(key string) (string, error) {
+  msgs, err := b.rc.XRevRangeN(key, "+", "-", 1).Result()
+  if err != nil && err != redis.Nil { return "", errors.Wrap(err, 0) }
+  if len(msgs) == 0 { return "0-0", nil }
+  return msgs[0].ID, nil
+}
+
+func stringsToAnys(strs []string) []interface{} {
+  var anys = make([]interface{}, len(strs))
+  for i, s := range strs {
+    anys[i] = s
+  }
+  return anys
+}
@@ -5,11 +5,13 @@ import (
   "fmt"
   "database/sql"
   "encoding/json"
+  "strconv"
   "time"
   "github.com/gin-gonic/gin"
   "tezos-contests.izibi.com/backend/auth"
   j "tezos-contests.izibi.com/backend/jase"
   "tezos-contests.izibi.com/backend/blocks"
+  "tezos-contests.izibi.com/backend/events"
   "tezos-contests.izibi.com/backend/model"
   "tezos-contests.izibi.com/backend/utils"
   "tezos-contests.izibi.com/backend/view"
@@ -23,11 +25,34 @@ func (svc *Service) RouteChains(r gin.IRoutes) {
     userId, ok := auth.GetUserId(c)
     if !ok { r.BadUser(); return }
     contestId := view.ImportId(c.Query("contestId"))
-    err := v.ViewChains(userId, contestId, view.ChainFilters{
+    filters := view.ChainFilters{
       Status: c.Query("status"),
-    })
+      TeamId: c.Query("teamId"),
+      Search: c.Query("search"),
+      ParentId: c.Query("parentId"),
+      Sort: c.Query("sort"),
+      Cursor: c.Query("cursor"),
+    }
+    var err error
+    if limit := c.Query("limit"); limit != "" {
+      filters.Limit, err = strconv.Atoi(limit)
+      if err != nil { r.StringError("bad limit"); return }
+    }
+    dates := []struct{ param string; dst *time.Time }{
+      {"createdAfter", &filters.CreatedAfter},
+      {"createdBefore", &filters.CreatedBefore},
+      {"updatedAfter", &filters.UpdatedAfter},
+      {"updatedBefore", &filters.UpdatedBefore},
+    }
+    for _, date := range dates {
+      if str := c.Query(date.param); str != "" {
+        *date.dst, err = time.Parse(time.RFC3339, str)
+        if err != nil { r.StringError("bad " + date.param); return }
+      }
+    }
+    err = v.ViewChains(userId, contestId, filters)
     if err != nil { r.Error(err); return }
-    r.Send(v.Flat())
+    r.SendStream(v.Flat())
   })
 
   r.GET("/Chains/:chainId", func(c *gin.Context) {
@@ -47,6 +72,79 @@ func (svc *Service) RouteChains(r gin.IRoutes) {
     r.Send(v.Flat())
   })
 
+  r.GET("/Chains/:chainId/Lineage", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    v := view.New(svc.model)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    chainId := view.ImportId(c.Param("chainId"))
+    chain, err := svc.model.LoadChain(chainId)
+    if err != nil { r.Error(err); return }
+    team, err := svc.model.LoadUserContestTeam(userId, chain.Contest_id)
+    if err != nil { r.Error(err); return }
+    if team == nil { r.StringError("access denied"); return }
+    v.SetTeam(team.Id) // view will redact private chains of other teams
+    err = v.ViewChainLineage(chainId)
+    if err != nil { r.Error(err); return }
+    r.Send(v.Flat())
+  })
+
+  /*
+    Shows how the protocol of chainId differs from that of baseId.
+    With ?source=text (the default) the chains' interface and implementation
+    texts are compared, with ?source=protocol the compiled protocols
+    (Protocol_hash) are loaded from the block store and compared.
+  */
+  r.GET("/Chains/:chainId/ProtocolDiff/:baseId", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    v := view.New(svc.model)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    chain, err := svc.model.LoadChain(view.ImportId(c.Param("chainId")))
+    if err != nil { r.Error(err); return }
+    base, err := svc.model.LoadChain(view.ImportId(c.Param("baseId")))
+    if err != nil { r.Error(err); return }
+    if chain.Contest_id != base.Contest_id { r.StringError("contest mismatch"); return }
+    team, err := svc.model.LoadUserContestTeam(userId, chain.Contest_id)
+    if err != nil { r.Error(err); return }
+    if team == nil { r.StringError("access denied"); return }
+    v.SetTeam(team.Id)
+    if !v.CanViewChain(chain) || !v.CanViewChain(base) {
+      r.StringError("access denied"); return
+    }
+    diff := view.ProtocolDiff{Chain: chain, Base: base, Source: c.DefaultQuery("source", "text")}
+    switch diff.Source {
+    case "text":
+      chainDir := fmt.Sprintf("chains/%s", view.ExportId(chain.Id))
+      baseDir := fmt.Sprintf("chains/%s", view.ExportId(base.Id))
+      diff.Interface = utils.UnifiedDiff(
+        baseDir + "/protocol.mli", base.Interface_text,
+        chainDir + "/protocol.mli", chain.Interface_text)
+      diff.Implementation = utils.UnifiedDiff(
+        baseDir + "/protocol.ml", base.Implementation_text,
+        chainDir + "/protocol.ml", chain.Implementation_text)
+    case "protocol":
+      if chain.Protocol_hash == "" || base.Protocol_hash == "" {
+        r.StringError("no protocol on chain"); return
+      }
+      chainIntf, chainImpl, err := svc.store.LoadProtocol(chain.Protocol_hash)
+      if err != nil { r.Error(err); return }
+      baseIntf, baseImpl, err := svc.store.LoadProtocol(base.Protocol_hash)
+      if err != nil { r.Error(err); return }
+      diff.Interface = utils.UnifiedDiff(
+        base.Protocol_hash + "/bare_protocol.mli", string(baseIntf),
+        chain.Protocol_hash + "/bare_protocol.mli", string(chainIntf))
+      diff.Implementation = utils.UnifiedDiff(
+        base.Protocol_hash + "/bare_protocol.ml", string(baseImpl),
+        chain.Protocol_hash + "/bare_protocol.ml", string(chainImpl))
+    default:
+      r.StringError("bad source"); return
+    }
+    err = v.ViewChainProtocolDiff(&diff)
+    if err != nil { r.Error(err); return }
+    r.Send(v.Flat())
+  })
+
   r.POST("/Chains/:chainId/Update", func (c *gin.Context) {
     r := utils.NewResponse(c)
     v := view.New(svc.model)
@@ -116,22 +214,25 @@ func (svc *Service) RouteChains(r gin.IRoutes) {
     if err != nil { r.Error(err); return }
     /*
       The user must belong to a team in contest chain.contest_id.
-      TODO: quotas on number of private chains per team?
     */
     team, err := svc.model.LoadUserContestTeam(userId, oldChain.Contest_id)
     if err != nil { r.Error(err); return }
     if team == nil { r.StringError("access denied"); return }
 
     var newChainId int64
-    err = svc.model.Transaction(c, func() (err error) {
+    err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+
+      /* The fork is a new private chain with a new game. */
+      err = tx.CheckTeamQuotas(team.Id, 1, 1)
+      if err != nil { return }
 
-      newChainId, err = svc.model.ForkChain(team.Id, oldChainId, req.Title)
+      newChainId, err = tx.ForkChain(team.Id, oldChainId, req.Title)
       if err != nil { return }
 
       /* Initialize a game on the new chain. */
-      newChain, err := svc.model.LoadChain(newChainId)
+      newChain, err := tx.LoadChain(newChainId)
       if err != nil { return }
-      oldGame, err := svc.model.LoadGame(oldChain.Game_key)
+      oldGame, err := tx.LoadGame(oldChain.Game_key)
       if err != nil { return }
       block, err := svc.store.ReadBlock(oldGame.Last_block)
       if err != nil { return }
@@ -153,9 +254,9 @@ func (svc *Service) RouteChains(r gin.IRoutes) {
         Nb_players: setupParams.Nb_players,
         Cycles_per_round: setupParams.NbCyclesPerRound,
       }
-      gameKey, err := svc.model.CreateGame(newChain.Owner_id.Int64, firstBlock, gameParams)
+      gameKey, err := tx.CreateGame(newChain.Owner_id.Int64, firstBlock, gameParams)
       if err != nil { return }
-      err = svc.model.SetChainGameKey(newChainId, gameKey)
+      err = tx.SetChainGameKey(newChainId, gameKey)
       if err != nil { return }
       return nil
 
@@ -163,8 +264,8 @@ func (svc *Service) RouteChains(r gin.IRoutes) {
     if err != nil { r.Error(err); return }
 
     /* XXX Temporary, post on team channel as chain is private */
-    message := fmt.Sprintf("chain %s created", view.ExportId(newChainId))
-    svc.events.PostContestMessage(team.Contest_id, message)
+    svc.events.PostContestEvent(team.Contest_id,
+      events.ChainCreatedEvent(view.ExportId(newChainId)).ForTeam(team.Id))
 
     r.Result(j.String(view.ExportId(newChainId)))
   })
@@ -178,13 +279,16 @@ func (svc *Service) RouteChains(r gin.IRoutes) {
     if err != nil { r.Error(err); return }
 
     /* XXX temporary */
-    message := fmt.Sprintf("chain %s deleted", view.ExportId(chain.Id))
-    svc.events.PostContestMessage(chain.Contest_id, message)
+    event := events.ChainDeletedEvent(view.ExportId(chain.Id))
+    if chain.Owner_id.Valid {
+      event.ForTeam(chain.Owner_id.Int64)
+    }
+    svc.events.PostContestEvent(chain.Contest_id, event)
     /*
     if chain.Status_id == 1 { // XXX should query model to test if chain is private
-      svc.events.PostTeamMessage(chain.Owner_id.Int64, message)
+      svc.events.PostTeamEvent(chain.Owner_id.Int64, event)
     } else {
-      svc.events.PostContestMessage(chain.Contest_id, message)
+      svc.events.PostContestEvent(chain.Contest_id, event)
     }
     */
 
@@ -212,23 +316,38 @@ func (svc *Service) RouteChains(r gin.IRoutes) {
     if setupHash == "" { r.StringError("no setup block"); return }
     /* Load params from the store to keep task-specific params. */
     bsParams, err := svc.store.ReadResource(setupHash, "params.json")
-    if err != nil { r.Error(err) }
+    if err != nil { r.Error(err); return }
     var gameParams model.GameParams
     err = json.Unmarshal(bsParams, &gameParams)
     if err != nil { r.Error(err); return }
-    setupHash, err = svc.store.MakeSetupBlock(protoHash, bsParams)
-    if err != nil { r.Error(err) }
-    gameKey, err := svc.model.CreateGame(team.Id, setupHash, gameParams)
+    /* Check the quotas before building the setup block (which compiles the
+       setup code), and again in the transaction that creates the game.
+       A block left unused by a failed transaction can be reused. */
+    err = svc.model.CheckTeamQuotas(team.Id, 0, 1)
     if err != nil { r.Error(err); return }
-    err = svc.model.SaveChainRevision(chain)
+    setupHash, err = svc.store.MakeSetupBlock(protoHash, bsParams)
     if err != nil { r.Error(err); return }
-    chain.Updated_at = time.Now()
-    chain.Started_at = sql.NullString{}
-    chain.Game_key = gameKey
-    err = svc.model.SaveChain(chain)
+    err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+      err = tx.CheckTeamQuotas(team.Id, 0, 1)
+      if err != nil { return }
+      gameKey, err := tx.CreateGame(team.Id, setupHash, gameParams)
+      if err != nil { return }
+      chain, err = tx.LoadChainForUpdate(chainId)
+      if err != nil { return }
+      err = tx.SaveChainRevision(chain)
+      if err != nil { return }
+      chain.Updated_at = time.Now()
+      chain.Started_at = sql.NullString{}
+      chain.Game_key = gameKey
+      return tx.SaveChain(chain)
+    })
     if err != nil { r.Error(err); return }
-    message := fmt.Sprintf("chain %s restarted", view.ExportId(chainId))
-    svc.events.PostContestMessage(team.Contest_id, message)
+    svc.chargeBlock(team.Id, setupHash)
+    event := events.ChainRestartedEvent(view.ExportId(chainId))
+    if chain.Owner_id.Valid {
+      event.ForTeam(chain.Owner_id.Int64)
+    }
+    svc.events.PostContestEvent(team.Contest_id, event)
     v := view.New(svc.model)
     err = v.ViewChain(userId, chainId)
     if err != nil { r.Error(err); return }
@@ -0,0 +1,182 @@
+/*
+
+  Batch requests
+
+  A "batch" game request carries, under a single signature, an array of
+  "items" which are game requests without author (the batch's author is
+  used) and with the batch's game key.  Supported item actions are
+  "register bots", "enter commands", "close round" and "cancel_round".
+
+  The commands of all "enter commands" items are checked first (grouping
+  the items by current block so that the task tool is run once per block
+  when it supports it), then all items are performed in order in a single
+  transaction.  The result holds one entry per item, in the same order,
+  with either a "result" (and "receipt" for entered commands) or an "error".
+  An item failing does not prevent the following items from being
+  performed.  The action of each item performed is recorded in the team's
+  game actions (the batch itself is not).
+
+*/
+
+package routes
+
+import (
+  "errors"
+  "github.com/gin-gonic/gin"
+  "tezos-contests.izibi.com/backend/events"
+  j "tezos-contests.izibi.com/backend/jase"
+  "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/utils"
+)
+
+/* Maximum number of items in a batch request. */
+const MaxBatchSize = 64
+
+type batchItem struct {
+  req *GameRequest
+  commands []byte /* "enter commands", once checked */
+  rank uint32 /* "enter commands" */
+  ranks []uint32 /* "register bots" */
+  game *model.Game /* "enter commands", "close round" */
+  result j.Value
+  receipt j.Value
+  err error /* from the checks, or once the transaction has committed */
+  txErr error /* from performing the item in the transaction */
+}
+
+func gameBatch(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, key *model.TeamKey) {
+  var err error
+  teamId := key.Team_id
+  if len(req.Items) == 0 { r.StringError("empty batch"); return }
+  if len(req.Items) > MaxBatchSize { r.StringError("batch is too large"); return }
+  items := make([]batchItem, len(req.Items))
+  checks := make(map[string][]*batchItem) /* by current block */
+  var blockOrder []string
+  for i := range req.Items {
+    item := &items[i]
+    item.req = &req.Items[i]
+    if item.req.GameKey == "" {
+      item.req.GameKey = req.GameKey
+    } else if item.req.GameKey != req.GameKey {
+      item.err = errors.New("game key mismatch")
+      continue
+    }
+    switch item.req.Action {
+    case "enter commands":
+      block := item.req.CurrentBlock
+      if _, ok := checks[block]; !ok {
+        blockOrder = append(blockOrder, block)
+      }
+      checks[block] = append(checks[block], item)
+    case "register bots", "close round", "cancel_round":
+    default:
+      item.err = errors.New("bad batch action")
+    }
+  }
+  for _, blockHash := range blockOrder {
+    group := checks[blockHash]
+    block, err := svc.store.ReadBlock(blockHash)
+    if err != nil {
+      for _, item := range group { item.err = err }
+      continue
+    }
+    texts := make([]string, len(group))
+    for i, item := range group {
+      texts[i] = item.req.Commands
+    }
+    results, err := svc.store.CheckCommandsBatch(block.Base(), texts)
+    if err != nil { r.Error(err); return }
+    for i, item := range group {
+      item.commands, item.err = results[i].Commands, results[i].Err
+    }
+  }
+  err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) error {
+    for i := range items {
+      item := &items[i]
+      if item.err != nil { continue }
+      /* The writes of an item that fails are rolled back, those of the
+         other items are committed. */
+      var err error
+      item.txErr, err = tx.Savepoint("batch_item", func () error {
+        return performBatchItem(tx, item, key)
+      })
+      if err != nil { return err }
+      /* Have the whole transaction attempted again. */
+      if model.IsRetryableError(item.txErr) { return item.txErr }
+    }
+    return nil
+  })
+  if err != nil { r.Error(err); return }
+  results := j.Array()
+  for i := range items {
+    item := &items[i]
+    if item.err == nil {
+      item.err = item.txErr
+    }
+    if item.err == nil {
+      item.err = svc.completeBatchItem(item, teamId)
+    }
+    res := j.Object()
+    if item.err != nil {
+      res.Prop("error", j.String(item.err.Error()))
+    } else {
+      res.Prop("result", item.result)
+      if item.receipt != nil {
+        res.Prop("receipt", item.receipt)
+      }
+    }
+    results.Item(res)
+  }
+  r.Result(results)
+}
+
+/* Performs the database part of a batch item, within the batch's
+   transaction, and records its action. */
+func performBatchItem(tx *model.Tx, item *batchItem, key *model.TeamKey) (err error) {
+  req := item.req
+  teamId := key.Team_id
+  switch req.Action {
+  case "register bots":
+    item.ranks, err = tx.RegisterGamePlayers(req.GameKey, teamId, req.BotIds)
+  case "enter commands":
+    item.game, item.rank, err = tx.SetPlayerCommands(req.GameKey, req.CurrentBlock, teamId, req.Player, item.commands)
+  case "close round":
+    item.game, err = tx.CloseRound(req.GameKey, req.CurrentBlock)
+  case "cancel_round":
+    _, err = tx.CancelRound(req.GameKey)
+  }
+  if err != nil { return }
+  return tx.RecordGameAction(req.GameKey, key, req.Action)
+}
+
+/* Completes a batch item once the batch's transaction has committed, and
+   sets its result. */
+func (svc *Service) completeBatchItem(item *batchItem, teamId int64) error {
+  req := item.req
+  switch req.Action {
+  case "register bots":
+    jRanks := j.Array()
+    for _, n := range item.ranks {
+      jRanks.Item(j.Uint32(n))
+    }
+    res := j.Object()
+    res.Prop("ranks", jRanks)
+    item.result = res
+  case "enter commands":
+    receipt, err := svc.commandsReceipt(item.game, teamId, item.rank, item.commands)
+    if err != nil { return err }
+    item.result = j.Raw(item.commands)
+    item.receipt = j.Raw(receipt)
+  case "close round":
+    game := item.game
+    ranks := commandRanks(game.Next_block_commands)
+    svc.events.PostGameEvent(req.GameKey, events.RoundClosedEvent(req.GameKey, game.Current_round, ranks))
+    svc.startBlockBuild(game)
+    res := j.Object()
+    res.Prop("commands", j.Raw(game.Next_block_commands))
+    item.result = res
+  case "cancel_round":
+    item.result = j.Null
+  }
+  return nil
+}
@@ -25,6 +25,7 @@ import (
   "time"
   "github.com/gin-gonic/gin"
   "tezos-contests.izibi.com/backend/blocks"
+  "tezos-contests.izibi.com/backend/events"
   "tezos-contests.izibi.com/backend/model"
   "tezos-contests.izibi.com/backend/utils"
   "tezos-contests.izibi.com/backend/view"
@@ -46,6 +47,19 @@ type GameRequest struct {
   Payload string `json:"payload"` /* "pong" */
   Player uint32 `json:"player"` /* "enter commands" */
   Timestamp string `json:"timestamp"` /* "ping", "pong" -- Unix time, milliseconds, as string */
+  Items []GameRequest `json:"items"` /* "batch" */
+}
+
+func isGameOwnerAction(req *GameRequest) bool {
+  switch req.Action {
+  case "close round", "cancel_round", "ping":
+    return true
+  case "batch":
+    for i := range req.Items {
+      if isGameOwnerAction(&req.Items[i]) { return true }
+    }
+  }
+  return false
 }
 
 func (svc *Service) RouteGames(routes gin.IRoutes) {
@@ -95,6 +109,8 @@ func (svc *Service) RouteGames(routes gin.IRoutes) {
     result := j.Object()
     result.Prop("page", j.Uint64(page))
     result.Prop("blocks", j.Raw(blocks))
+    /* Not streamed: an encoding error would leave a truncated response
+       in caches. */
     c.Header("Cache-Control", "public, max-age=86400, immutable") // 1 day
     r.Result(result)
   })
@@ -137,9 +153,11 @@ func (svc *Service) RouteGames(routes gin.IRoutes) {
       Cycles_per_round: setupParams.NbCyclesPerRound,
     }
     var gameKey string
-    err = svc.model.Transaction(c, func () (err error) {
+    err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+      err = tx.CheckTeamQuotas(teamId, 0, 1)
+      if err != nil { return }
       /* TODO: check that there is no game by the same team with created_at = req.Timestamp ? */
-      gameKey, err = svc.model.CreateGame(teamId, req.FirstBlock, gameParams)
+      gameKey, err = tx.CreateGame(teamId, req.FirstBlock, gameParams)
       return
     })
     if err != nil { r.Error(err); return }
@@ -152,32 +170,36 @@ func (svc *Service) RouteGames(routes gin.IRoutes) {
     var req GameRequest
     r, err := svc.signedRequest(c, &req)
     if err != nil { r.Error(err); return }
-    teamId, err := svc.checkAuthor(req.Author)
+    key, err := svc.checkAuthorKey(req.Author)
     if err != nil { r.Error(err); return }
+    teamId := key.Team_id
     if req.GameKey != c.Param("gameKey") {
       r.StringError("game key mismatch")
       return
     }
     // Some actions can only be performed by the game owner.
-    switch req.Action {
-    case "close round", "cancel_round", "ping":
+    if isGameOwnerAction(&req) {
       ok, err := svc.model.IsGameOwner(req.GameKey, teamId)
       if err != nil { r.Error(err); return }
       if !ok { r.StringError("not game owner"); return }
     }
+    /* The handlers keep track of the key used for each action that
+       succeeds (with model.RecordGameAction, in the action's transaction). */
     switch req.Action {
     case "register bots":
-      gameRegisterBots(svc, c, r, &req, teamId)
+      gameRegisterBots(svc, c, r, &req, key)
     case "enter commands":
-      gameEnterCommands(svc, c, r, &req, teamId)
+      gameEnterCommands(svc, c, r, &req, key)
     case "close round":
-      gameCloseRound(svc, c, r, &req)
+      gameCloseRound(svc, c, r, &req, key)
     case "cancel_round":
-      gameCancelRound(svc, c, r, &req)
+      gameCancelRound(svc, c, r, &req, key)
     case "ping":
-      gamePing(svc, c, r, &req)
+      gamePing(svc, c, r, &req, key)
     case "pong":
-      gamePong(svc, c, r, &req)
+      gamePong(svc, c, r, &req, key)
+    case "batch":
+      gameBatch(svc, c, r, &req, key)
     default:
       r.StringError("bad action")
       return
@@ -224,12 +246,13 @@ func ViewPlayers(players []model.RegisteredGamePlayer) j.Value {
   return items
 }
 
-func gameRegisterBots(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, teamId int64) {
+func gameRegisterBots(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, key *model.TeamKey) {
   var err error
   var ranks []uint32
-  err = svc.model.Transaction(c, func () (err error) {
-    ranks, err = svc.model.RegisterGamePlayers(req.GameKey, teamId, req.BotIds)
-    return
+  err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+    ranks, err = tx.RegisterGamePlayers(req.GameKey, key.Team_id, req.BotIds)
+    if err != nil { return }
+    return tx.RecordGameAction(req.GameKey, key, req.Action)
   })
   if err != nil { r.Error(err); return }
   res := j.Object()
@@ -241,63 +264,76 @@ func gameRegisterBots(svc *Service, c *gin.Context, r *utils.Response, req *Game
   r.Result(res)
 }
 
-func gameEnterCommands(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, teamId int64) {
+func gameEnterCommands(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, key *model.TeamKey) {
   var err error
+  teamId := key.Team_id
   block, err := svc.store.ReadBlock(req.CurrentBlock)
   if err != nil { r.Error(err); return }
   cmds, err := svc.store.CheckCommands(block.Base(), req.Commands)
   if err != nil { r.Error(err); return }
-  err = svc.model.Transaction(c, func () error {
-    return svc.model.SetPlayerCommands(req.GameKey, req.CurrentBlock, teamId, req.Player, cmds)
+  var game *model.Game
+  var rank uint32
+  err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+    game, rank, err = tx.SetPlayerCommands(req.GameKey, req.CurrentBlock, teamId, req.Player, cmds)
+    if err != nil { return }
+    return tx.RecordGameAction(req.GameKey, key, req.Action)
   })
   if err != nil { r.Error(err); return }
-  r.Result(j.Raw(cmds))
+  receipt, err := svc.commandsReceipt(game, teamId, rank, cmds)
+  if err != nil { r.Error(err); return }
+  r.ResultWithReceipt(j.Raw(cmds), j.Raw(receipt))
 }
 
-func gameCloseRound(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest) {
+func gameCloseRound(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, key *model.TeamKey) {
   var err error
   var game *model.Game
-  err = svc.model.Transaction(c, func () (err error) {
-    game, err = svc.model.CloseRound(req.GameKey, req.CurrentBlock)
-    return err
+  err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+    game, err = tx.CloseRound(req.GameKey, req.CurrentBlock)
+    if err != nil { return }
+    return tx.RecordGameAction(req.GameKey, key, req.Action)
   })
   if err != nil { r.Error(err); return }
-  go func () {
-    /* XXX the game will not unlock if the backend crashes before this routine completes */
-    var err error
-    var newBlock string
-    newBlock, err = svc.store.MakeCommandBlock(game.Last_block, game.Next_block_commands)
-    if err != nil { /* TODO: mark error in block */ return }
-    err = svc.store.ClearHeadIndex(req.GameKey)
-    if err != nil { /* TODO: mark error in block */ return }
-    err = svc.model.Transaction(c, func () (err error) {
-      _, err = svc.model.EndRoundAndUnlock(req.GameKey, newBlock)
-      if err != nil { /* TODO: mark error in block */ return }
-      return
-    })
-    if err != nil {
-      // TODO: post an error!
-    } else {
-      svc.events.PostGameMessage(req.GameKey, newBlockMessage(newBlock))
-    }
-  }()
+  ranks := commandRanks(game.Next_block_commands)
+  svc.events.PostGameEvent(req.GameKey, events.RoundClosedEvent(req.GameKey, game.Current_round, ranks))
+  svc.startBlockBuild(game)
   res := j.Object()
   res.Prop("commands", j.Raw(game.Next_block_commands))
   r.Result(res)
 }
 
-func gameCancelRound(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest) {
+/* Returns the ranks of the players having commands in a round's commands
+   (an array of cycles, each an array of {player, command}). */
+func commandRanks(commands []byte) []uint32 {
+  var cycles [][]struct {
+    Player uint32 `json:"player"`
+  }
+  var ranks []uint32
+  if json.Unmarshal(commands, &cycles) != nil { return ranks }
+  seen := make(map[uint32]bool)
+  for _, cycle := range cycles {
+    for _, cmd := range cycle {
+      if !seen[cmd.Player] {
+        seen[cmd.Player] = true
+        ranks = append(ranks, cmd.Player)
+      }
+    }
+  }
+  return ranks
+}
+
+func gameCancelRound(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, key *model.TeamKey) {
   var err error
   if err != nil { r.Error(err); return }
-  err = svc.model.Transaction(c, func () (err error) {
-    _, err = svc.model.CancelRound(req.GameKey)
-    return
+  err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+    _, err = tx.CancelRound(req.GameKey)
+    if err != nil { return }
+    return tx.RecordGameAction(req.GameKey, key, req.Action)
   })
   if err != nil { r.Error(err); return }
   r.Result(j.Null)
 }
 
-func gamePing(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest) {
+func gamePing(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, teamKey *model.TeamKey) {
   var err error
   var pingTime time.Time
   pingTime, err = parseUnixMillis(req.Timestamp)
@@ -306,11 +342,16 @@ func gamePing(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest)
   var bots []model.GamePlayerId
   bots, err = svc.model.LoadGamePlayerIds(req.GameKey)
   if err != nil { r.Error(err); return }
+  err = svc.model.RecordGameAction(req.GameKey, teamKey, req.Action)
+  if err != nil { r.Error(err); return }
   key, err := utils.NewKey()
   if err != nil { r.StringError("failed to generate a key"); return }
-  sub := svc.rc.Subscribe(pingChannel(key))
-  var message = fmt.Sprintf("ping %s", key)
-  svc.events.PostGameMessage(req.GameKey, message)
+  sub := svc.pubsub.Subscribe(pingChannel(key))
+  var ranks = make([]uint32, len(bots))
+  for i, bot := range bots {
+    ranks[i] = bot.Rank
+  }
+  svc.events.PostGameEvent(req.GameKey, events.PingEvent(req.GameKey, key, ranks))
   var timeout = time.NewTimer(2 * time.Second)
   var ch = sub.Channel()
   var nbExpected = len(bots)
@@ -382,16 +423,19 @@ func gamePing(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest)
   })
 }
 
-func gamePong(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest) {
-  message := PongMessage{req.Timestamp, req.Author[1:], req.BotIds}
-  svc.rc.Publish(pingChannel(req.Payload), message.Encode())
+func gamePong(svc *Service, c *gin.Context, r *utils.Response, req *GameRequest, key *model.TeamKey) {
+  /* Bots are identified by their team's primary key, whichever key
+     signed the request. */
+  team, err := svc.model.LoadTeam(key.Team_id)
+  if err != nil { r.Error(err); return }
+  message := PongMessage{req.Timestamp, team.Public_key, req.BotIds}
+  err = svc.pubsub.Publish(pingChannel(req.Payload), message.Encode())
+  if err != nil { r.Error(err); return }
+  err = svc.model.RecordGameAction(req.GameKey, key, req.Action)
+  if err != nil { r.Error(err); return }
   r.Result(j.Boolean(true))
 }
 
-func newBlockMessage(hash string) string {
-  return fmt.Sprintf("block %s", hash)
-}
-
 func pingChannel(key string) string {
   return fmt.Sprintf("ping:%s", key)
 }
@@ -0,0 +1,163 @@
+/*
+  Block builds.
+
+  Closing a game round computes the next block in the background while the
+  game stays locked.  Builds are tracked so that a shutdown can wait for
+  them; builds that do not complete in time are flagged in the database and
+  resumed by the instances that are still running (or by the next one to
+  start), which look for flagged games periodically.
+*/
+
+package routes
+
+import (
+  "context"
+  "fmt"
+  "sync"
+  "time"
+  "tezos-contests.izibi.com/backend/events"
+  "tezos-contests.izibi.com/backend/model"
+)
+
+/* Interval between two looks for block builds to recover. */
+var RecoveryInterval = 1 * time.Minute
+
+type blockJobs struct {
+  mutex sync.Mutex
+  wg sync.WaitGroup
+  running map[string]bool /* game keys */
+  stopping bool
+  stop chan struct{} /* closed on shutdown */
+}
+
+/* Starts computing the next block of a locked game. */
+func (svc *Service) startBlockBuild(game *model.Game) {
+  jobs := &svc.jobs
+  jobs.mutex.Lock()
+  if jobs.stopping {
+    jobs.mutex.Unlock()
+    svc.markForRecovery([]string{game.Game_key})
+    return
+  }
+  jobs.running[game.Game_key] = true
+  jobs.wg.Add(1)
+  jobs.mutex.Unlock()
+  go func () {
+    defer func () {
+      jobs.mutex.Lock()
+      delete(jobs.running, game.Game_key)
+      jobs.mutex.Unlock()
+      jobs.wg.Done()
+    }()
+    svc.buildBlock(game)
+  }()
+}
+
+func (svc *Service) buildBlock(game *model.Game) {
+  /* XXX the game will not unlock if the backend crashes before this routine completes */
+  var err error
+  var newBlock string
+  gameKey := game.Game_key
+  newBlock, err = svc.store.MakeCommandBlock(game.Last_block, game.Next_block_commands)
+  if err != nil { /* TODO: mark error in block */ return }
+  err = svc.store.ClearHeadIndex(gameKey)
+  if err != nil { /* TODO: mark error in block */ return }
+  err = svc.model.Transaction(context.Background(), func (tx *model.Tx) (err error) {
+    _, err = tx.EndRoundAndUnlock(gameKey, newBlock)
+    if err != nil { /* TODO: mark error in block */ return }
+    return
+  })
+  if err != nil {
+    // TODO: post an error!
+  } else {
+    err = svc.blockReceipt(game, newBlock)
+    if err != nil {
+      fmt.Printf("failed to sign receipt for block %s: %v\n", newBlock, err)
+    }
+    svc.chargeBlock(game.Owner_id, newBlock)
+    ranks := commandRanks(game.Next_block_commands)
+    svc.events.PostGameEvent(gameKey,
+      events.NewBlockEvent(gameKey, newBlock, game.Current_round, ranks))
+  }
+}
+
+/*
+  Waits for the running block builds to complete.  If the context expires
+  first, the games of the builds still running are flagged for recovery.
+  Builds requested after this call are flagged immediately.
+*/
+func (svc *Service) Shutdown(ctx context.Context) error {
+  jobs := &svc.jobs
+  jobs.mutex.Lock()
+  if !jobs.stopping {
+    jobs.stopping = true
+    close(jobs.stop)
+  }
+  jobs.mutex.Unlock()
+  done := make(chan struct{})
+  go func () {
+    jobs.wg.Wait()
+    close(done)
+  }()
+  select {
+  case <-done:
+    return nil
+  case <-ctx.Done():
+  }
+  var gameKeys []string
+  jobs.mutex.Lock()
+  for gameKey := range jobs.running {
+    gameKeys = append(gameKeys, gameKey)
+  }
+  jobs.mutex.Unlock()
+  svc.markForRecovery(gameKeys)
+  return ctx.Err()
+}
+
+func (svc *Service) markForRecovery(gameKeys []string) {
+  err := svc.model.MarkGamesForRecovery(gameKeys)
+  if err != nil {
+    fmt.Printf("failed to mark games %v for recovery: %v\n", gameKeys, err)
+    return
+  }
+  if len(gameKeys) != 0 {
+    fmt.Printf("games %v marked for recovery\n", gameKeys)
+  }
+}
+
+/*
+  Restarts the block builds left unfinished by the shutdown of an instance,
+  now and then every RecoveryInterval until shutdown.  During a rolling
+  deploy, builds are flagged after the new instance has started.
+  This method is intended to be invoked as a go routine.
+*/
+func (svc *Service) RecoverBlockBuilds() {
+  ticker := time.NewTicker(RecoveryInterval)
+  defer ticker.Stop()
+  for {
+    svc.recoverBlockBuilds()
+    select {
+    case <-ticker.C:
+    case <-svc.jobs.stop:
+      return
+    }
+  }
+}
+
+func (svc *Service) recoverBlockBuilds() {
+  for {
+    select {
+    case <-svc.jobs.stop:
+      return
+    default:
+    }
+    game, err := svc.model.ClaimGameRecovery()
+    if err != nil {
+      fmt.Printf("failed to recover block builds: %v\n", err)
+      return
+    }
+    if game == nil { return }
+    fmt.Printf("recovering block build for game %s\n", game.Game_key)
+    svc.startBlockBuild(game)
+  }
+}
@@ -0,0 +1,135 @@
+/*
+
+  Team and game messages
+
+  Members of a team and its bots can post short typed messages to the team
+  channel, and the owner of a game can post announcements to the game
+  channel.  Messages are retained in the channels' history like other
+  events, so that team tooling can use them to coordinate bots.
+
+  A message has a kind (chosen by the sender, e.g. "strategy") and
+  arbitrary JSON data.
+
+*/
+
+package routes
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "regexp"
+  "time"
+  "github.com/gin-gonic/gin"
+  "tezos-contests.izibi.com/backend/auth"
+  "tezos-contests.izibi.com/backend/events"
+  "tezos-contests.izibi.com/backend/signing"
+  "tezos-contests.izibi.com/backend/utils"
+  "tezos-contests.izibi.com/backend/view"
+)
+
+/* Maximum size of the JSON data of a message, in bytes. */
+var MaxMessageDataSize = 4096
+
+/* Messages allowed per minute on a team channel. */
+var TeamMessageRate int64 = 60
+
+/* Announcements allowed per minute on a game channel. */
+var GameAnnouncementRate int64 = 10
+
+var messageKindRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+type messageRequest struct {
+  Author string `json:"author"` /* team public key if signed, absent otherwise */
+  Kind string `json:"kind"`
+  Data json.RawMessage `json:"data"`
+}
+
+func (svc *Service) RouteMessages(r gin.IRoutes) {
+
+  /*
+    The request is either signed with the team's key (by a bot) or made
+    by a member of the team.
+  */
+  r.POST("/Teams/:teamId/Messages", func (c *gin.Context) {
+    r := utils.NewResponse(c)
+    teamId := view.ImportId(c.Param("teamId"))
+    req, data, err := svc.readMessageRequest(c)
+    if err != nil { r.Error(err); return }
+    var userId string
+    if req.Author != "" {
+      authorId, err := svc.checkAuthor(req.Author)
+      if err != nil { r.Error(err); return }
+      if authorId != teamId { r.StringError("forbidden"); return }
+    } else {
+      id, ok := auth.GetUserId(c)
+      if !ok { r.BadUser(); return }
+      isMember, err := svc.model.IsUserInTeam(id, teamId)
+      if err != nil { r.Error(err); return }
+      if !isMember { r.StringError("forbidden"); return }
+      userId = view.ExportId(id)
+    }
+    err = svc.checkMessageRate(fmt.Sprintf("team:%d", teamId), TeamMessageRate)
+    if err != nil { r.Error(err); return }
+    svc.events.PostTeamEvent(teamId,
+      events.TeamMessageEvent(view.ExportId(teamId), userId, req.Kind, data))
+    r.Ok()
+  })
+
+  /* The request must be signed with the key of the team owning the game. */
+  r.POST("/Games/:gameKey/Announce", func (c *gin.Context) {
+    r := utils.NewResponse(c)
+    gameKey := c.Param("gameKey")
+    req, data, err := svc.readMessageRequest(c)
+    if err != nil { r.Error(err); return }
+    if req.Author == "" { r.StringError("signature required"); return }
+    teamId, err := svc.checkAuthor(req.Author)
+    if err != nil { r.Error(err); return }
+    isOwner, err := svc.model.IsGameOwner(gameKey, teamId)
+    if err != nil { r.Error(err); return }
+    if !isOwner { r.StringError("forbidden"); return }
+    err = svc.checkMessageRate(fmt.Sprintf("game:%s", gameKey), GameAnnouncementRate)
+    if err != nil { r.Error(err); return }
+    svc.events.PostGameEvent(gameKey, events.GameAnnouncementEvent(gameKey, req.Kind, data))
+    r.Ok()
+  })
+
+}
+
+/* Reads and checks a message request, verifying its signature if it has
+   an author.  Returns the request and its compacted data. */
+func (svc *Service) readMessageRequest(c *gin.Context) (*messageRequest, []byte, error) {
+  var err error
+  body, err := c.GetRawData()
+  if err != nil { return nil, nil, err }
+  var req messageRequest
+  err = json.Unmarshal(body, &req)
+  if err != nil { return nil, nil, err }
+  if req.Author != "" {
+    err = signing.Verify(svc.config.ApiKey, body)
+    if err != nil { return nil, nil, err }
+  }
+  if !messageKindRe.MatchString(req.Kind) {
+    return nil, nil, errors.New("bad message kind")
+  }
+  if len(req.Data) == 0 {
+    req.Data = json.RawMessage("null")
+  }
+  var data bytes.Buffer
+  err = json.Compact(&data, req.Data)
+  if err != nil { return nil, nil, err }
+  if data.Len() > MaxMessageDataSize {
+    return nil, nil, errors.New("message is too large")
+  }
+  return &req, data.Bytes(), nil
+}
+
+/* Counts a message against the per-minute limit of a channel. */
+func (svc *Service) checkMessageRate(channel string, limit int64) error {
+  window := time.Now().Unix() / 60
+  n, err := svc.cache.Incr(fmt.Sprintf("ratelimit:%s:%d", channel, window), time.Minute)
+  if err != nil { return err }
+  if n > limit { return errors.New("too many messages, try again later") }
+  return nil
+}
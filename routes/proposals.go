@@ -0,0 +1,214 @@
+package routes
+
+import (
+  "fmt"
+  "time"
+  "github.com/gin-gonic/gin"
+  "tezos-contests.izibi.com/backend/auth"
+  "tezos-contests.izibi.com/backend/events"
+  "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/utils"
+  "tezos-contests.izibi.com/backend/view"
+)
+
+/*
+  Chain proposals let a team offer the protocol of one of its chains to the
+  owners of another chain (by default, the chain it was forked from).
+*/
+func (svc *Service) RouteProposals(r gin.IRoutes) {
+
+  r.GET("/Chains/:chainId/Proposals", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    v := view.New(svc.model)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    chainId := view.ImportId(c.Param("chainId"))
+    chain, err := svc.model.LoadChain(chainId)
+    if err != nil { r.Error(err); return }
+    team, err := svc.model.LoadUserContestTeam(userId, chain.Contest_id)
+    if err != nil { r.Error(err); return }
+    if team == nil { r.StringError("access denied"); return }
+    v.SetTeam(team.Id)
+    err = v.ViewChainProposals(chainId)
+    if err != nil { r.Error(err); return }
+    r.Send(v.Flat())
+  })
+
+  r.POST("/Chains/:chainId/Propose", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    var err error
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    var req struct {
+      TargetChainId string `json:"targetChainId"` /* defaults to the parent chain */
+      Title string `json:"title"`
+      Description string `json:"description"`
+    }
+    err = c.ShouldBindJSON(&req)
+    if err != nil { r.Error(err); return }
+    chainId := view.ImportId(c.Param("chainId"))
+    chain, err := svc.model.LoadChain(chainId)
+    if err != nil { r.Error(err); return }
+    targetId := view.ImportId(req.TargetChainId)
+    if targetId == 0 {
+      if !chain.Parent_id.Valid { r.StringError("chain has no parent"); return }
+      targetId = chain.Parent_id.Int64
+    }
+    proposalId, err := svc.model.CreateChainProposal(userId, chainId, targetId, req.Title, req.Description)
+    if err != nil { r.Error(err); return }
+    /* XXX temporary */
+    event := events.ProposalCreatedEvent(view.ExportId(proposalId), view.ExportId(targetId))
+    if target, err := svc.model.LoadChain(targetId); err == nil && target.Owner_id.Valid {
+      event.ForTeam(target.Owner_id.Int64)
+    }
+    svc.events.PostContestEvent(chain.Contest_id, event)
+    svc.sendChainProposal(r, userId, proposalId)
+  })
+
+  r.GET("/Proposals/:proposalId", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    svc.sendChainProposal(r, userId, view.ImportId(c.Param("proposalId")))
+  })
+
+  r.POST("/Proposals/:proposalId/Comment", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    var err error
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    var req struct {
+      Body string `json:"body"`
+    }
+    err = c.ShouldBindJSON(&req)
+    if err != nil { r.Error(err); return }
+    proposalId := view.ImportId(c.Param("proposalId"))
+    v, details, err := svc.loadChainProposal(userId, proposalId)
+    if err != nil { r.Error(err); return }
+    if !v.CanViewChainProposal(details.Proposal, details.Source, details.Target) {
+      r.StringError("access denied"); return
+    }
+    _, err = svc.model.AddChainProposalComment(proposalId, userId, req.Body)
+    if err != nil { r.Error(err); return }
+    svc.sendChainProposal(r, userId, proposalId)
+  })
+
+  r.POST("/Proposals/:proposalId/Accept", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    var err error
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    proposalId := view.ImportId(c.Param("proposalId"))
+    proposal, err := svc.model.LoadChainProposal(proposalId)
+    if err != nil { r.Error(err); return }
+    if proposal.Status != "open" { r.StringError("proposal is not open"); return }
+    target, err := svc.model.LoadChain(proposal.Target_chain_id)
+    if err != nil { r.Error(err); return }
+    ok, err = svc.model.CanResolveChainProposal(userId, target)
+    if err != nil { r.Error(err); return }
+    if !ok { r.StringError("access denied"); return }
+    /* The target chain's sources are replaced with those of the proposed
+       protocol, which becomes the protocol used on the next restart. */
+    intf, impl, err := svc.store.LoadProtocol(proposal.Protocol_hash)
+    if err != nil { r.Error(err); return }
+    err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+      /* Reload the target, so that changes made since it was loaded are
+         kept. */
+      target, err = tx.LoadChainForUpdate(proposal.Target_chain_id)
+      if err != nil { return }
+      err = tx.ResolveChainProposal(proposalId, userId, "accepted")
+      if err != nil { return }
+      err = tx.SaveChainRevision(target)
+      if err != nil { return }
+      target.Updated_at = time.Now()
+      target.Interface_text = string(intf)
+      target.Implementation_text = string(impl)
+      target.New_protocol_hash = proposal.Protocol_hash
+      target.Needs_recompile = false
+      return tx.SaveChain(target)
+    })
+    if err != nil { r.Error(err); return }
+    /* XXX temporary */
+    event := events.ProposalAcceptedEvent(view.ExportId(proposalId), view.ExportId(target.Id)).ForTeam(proposal.Team_id)
+    svc.events.PostContestEvent(proposal.Contest_id, event)
+    svc.sendChainProposal(r, userId, proposalId)
+  })
+
+  r.POST("/Proposals/:proposalId/Reject", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    var err error
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    proposalId := view.ImportId(c.Param("proposalId"))
+    proposal, err := svc.model.LoadChainProposal(proposalId)
+    if err != nil { r.Error(err); return }
+    target, err := svc.model.LoadChain(proposal.Target_chain_id)
+    if err != nil { r.Error(err); return }
+    ok, err = svc.model.CanResolveChainProposal(userId, target)
+    if err != nil { r.Error(err); return }
+    if !ok { r.StringError("access denied"); return }
+    err = svc.model.ResolveChainProposal(proposalId, userId, "rejected")
+    if err != nil { r.Error(err); return }
+    /* XXX temporary */
+    event := events.ProposalRejectedEvent(view.ExportId(proposalId), view.ExportId(target.Id)).ForTeam(proposal.Team_id)
+    svc.events.PostContestEvent(proposal.Contest_id, event)
+    svc.sendChainProposal(r, userId, proposalId)
+  })
+
+}
+
+/* Loads a proposal with its chains, and prepares a view for the user's team. */
+func (svc *Service) loadChainProposal(userId int64, proposalId int64) (*view.View, *view.ChainProposalDetails, error) {
+  var err error
+  var details view.ChainProposalDetails
+  details.Proposal, err = svc.model.LoadChainProposal(proposalId)
+  if err != nil { return nil, nil, err }
+  details.Source, err = svc.model.LoadChain(details.Proposal.Source_chain_id)
+  if err != nil { return nil, nil, err }
+  details.Target, err = svc.model.LoadChain(details.Proposal.Target_chain_id)
+  if err != nil { return nil, nil, err }
+  team, err := svc.model.LoadUserContestTeam(userId, details.Proposal.Contest_id)
+  if err != nil { return nil, nil, err }
+  if team == nil { return nil, nil, fmt.Errorf("access denied") }
+  v := view.New(svc.model)
+  v.SetTeam(team.Id)
+  return v, &details, nil
+}
+
+func (svc *Service) sendChainProposal(r *utils.Response, userId int64, proposalId int64) {
+  v, details, err := svc.loadChainProposal(userId, proposalId)
+  if err != nil { r.Error(err); return }
+  if !v.CanViewChainProposal(details.Proposal, details.Source, details.Target) {
+    r.StringError("access denied"); return
+  }
+  err = svc.diffChainProposal(details)
+  if err != nil { r.Error(err); return }
+  err = v.ViewChainProposal(details)
+  if err != nil { r.Error(err); return }
+  r.Send(v.Flat())
+}
+
+func (svc *Service) diffChainProposal(details *view.ChainProposalDetails) error {
+  proposal := details.Proposal
+  baseHash := details.Target.New_protocol_hash
+  if baseHash == "" || baseHash == proposal.Protocol_hash {
+    baseHash = details.Target.Protocol_hash
+  }
+  details.BaseProtocolHash = baseHash
+  var baseIntf, baseImpl []byte
+  if baseHash != "" {
+    var err error
+    baseIntf, baseImpl, err = svc.store.LoadProtocol(baseHash)
+    if err != nil { return err }
+  }
+  intf, impl, err := svc.store.LoadProtocol(proposal.Protocol_hash)
+  if err != nil { return err }
+  details.Interface = utils.UnifiedDiff(
+    baseHash + "/bare_protocol.mli", string(baseIntf),
+    proposal.Protocol_hash + "/bare_protocol.mli", string(intf))
+  details.Implementation = utils.UnifiedDiff(
+    baseHash + "/bare_protocol.ml", string(baseImpl),
+    proposal.Protocol_hash + "/bare_protocol.ml", string(impl))
+  return nil
+}
+
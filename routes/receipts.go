@@ -0,0 +1,157 @@
+/*
+
+  Receipts
+
+  The backend signs (with its own key, see config.ServerKey) a receipt for
+  the commands entered by each player and for each command block, so that
+  teams can prove what the backend accepted and applied.  Receipts are
+  signed messages (see the signing package) whose author is the backend's
+  public key:
+
+    {"type": "commands", "gameKey", "round", "blockHash", "rank",
+     "commandsHash", "timestamp", "author", "signature"}
+
+  for commands entered on top of the block blockHash, and
+
+    {"type": "block", "gameKey", "round", "parentHash", "blockHash",
+     "players": [{"rank", "commandsHash"}], "timestamp", "author", "signature"}
+
+  for the block computed from the commands of a round.
+  A commands hash is the SHA-256 (base64url) of the RFC 8785 canonical
+  JSON array of the texts of the commands.
+
+*/
+
+package routes
+
+import (
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "strconv"
+  "time"
+  "github.com/gin-gonic/gin"
+  j "tezos-contests.izibi.com/backend/jase"
+  "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/signing"
+  "tezos-contests.izibi.com/backend/utils"
+)
+
+/* Maximum number of receipts returned by the receipts route. */
+const ReceiptsPageSize = 200
+
+func (svc *Service) RouteReceipts(r gin.IRoutes) {
+
+  /* Lists a game's receipts, from the round given by the "fromRound"
+     query parameter on.  The backend's public key is included to check
+     them. */
+  r.GET("/Games/:gameKey/Receipts", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    var fromRound uint64
+    if s := c.Query("fromRound"); s != "" {
+      var err error
+      fromRound, err = strconv.ParseUint(s, 10, 64)
+      if err != nil { r.StringError("bad round"); return }
+    }
+    receipts, err := svc.model.LoadGameReceipts(c.Param("gameKey"), fromRound, ReceiptsPageSize)
+    if err != nil { r.Error(err); return }
+    items := j.Array()
+    for i := range receipts {
+      items.Item(j.Raw([]byte(receipts[i].Receipt)))
+    }
+    result := j.Object()
+    result.Prop("serverKey", j.String(svc.config.ServerKey.Public))
+    result.Prop("receipts", items)
+    r.Result(result)
+  })
+
+}
+
+/* Signs, stores and returns the receipt for the commands entered by a
+   player. */
+func (svc *Service) commandsReceipt(game *model.Game, teamId int64, rank uint32, commands []byte) ([]byte, error) {
+  var err error
+  texts, err := commandTexts(commands)
+  if err != nil { return nil, err }
+  msg := j.Object()
+  msg.Prop("type", j.String("commands"))
+  msg.Prop("gameKey", j.String(game.Game_key))
+  msg.Prop("round", j.Uint64(game.Current_round))
+  msg.Prop("blockHash", j.String(game.Last_block))
+  msg.Prop("rank", j.Uint32(rank))
+  msg.Prop("commandsHash", j.String(commandsHash(texts)))
+  receipt, err := svc.signReceipt(msg)
+  if err != nil { return nil, err }
+  err = svc.model.CreateGameReceipt(game.Game_key, game.Current_round, "commands", teamId, receipt)
+  if err != nil { return nil, err }
+  return receipt, nil
+}
+
+/* Signs and stores the receipt for a block built from the commands of a
+   locked game (see buildBlock). */
+func (svc *Service) blockReceipt(game *model.Game, blockHash string) error {
+  var err error
+  var cycles [][]struct {
+    Player uint32 `json:"player"`
+    Command string `json:"command"`
+  }
+  err = json.Unmarshal(game.Next_block_commands, &cycles)
+  if err != nil { return err }
+  var ranks []uint32
+  texts := make(map[uint32][]string)
+  for _, cycle := range cycles {
+    for _, cmd := range cycle {
+      if _, ok := texts[cmd.Player]; !ok {
+        ranks = append(ranks, cmd.Player)
+      }
+      texts[cmd.Player] = append(texts[cmd.Player], cmd.Command)
+    }
+  }
+  players := j.Array()
+  for _, rank := range ranks {
+    player := j.Object()
+    player.Prop("rank", j.Uint32(rank))
+    player.Prop("commandsHash", j.String(commandsHash(texts[rank])))
+    players.Item(player)
+  }
+  msg := j.Object()
+  msg.Prop("type", j.String("block"))
+  msg.Prop("gameKey", j.String(game.Game_key))
+  msg.Prop("round", j.Uint64(game.Current_round))
+  msg.Prop("parentHash", j.String(game.Last_block))
+  msg.Prop("blockHash", j.String(blockHash))
+  msg.Prop("players", players)
+  receipt, err := svc.signReceipt(msg)
+  if err != nil { return err }
+  return svc.model.CreateGameReceipt(game.Game_key, game.Current_round, "block", 0, receipt)
+}
+
+func (svc *Service) signReceipt(msg j.IObject) ([]byte, error) {
+  msg.Prop("timestamp", j.Time(time.Now()))
+  msg.Prop("author", j.String("@" + svc.config.ServerKey.Public))
+  bs, err := j.ToBytes(msg)
+  if err != nil { return nil, err }
+  return signing.Sign(svc.config.ServerKey.Private, svc.config.ApiKey, bs)
+}
+
+/* Extracts the texts of entered commands (an array of {text, ...}). */
+func commandTexts(commands []byte) ([]string, error) {
+  var cmds []json.RawMessage
+  err := json.Unmarshal(commands, &cmds)
+  if err != nil { return nil, err }
+  texts := make([]string, len(cmds))
+  for i, cmd := range cmds {
+    texts[i], _ = j.GetString(cmd, "text")
+  }
+  return texts, nil
+}
+
+func commandsHash(texts []string) string {
+  arr := j.Array()
+  for _, text := range texts {
+    arr.Item(j.String(text))
+  }
+  bs, _ := j.ToJCSBytes(arr)
+  sum := sha256.Sum256(bs)
+  return base64.URLEncoding.EncodeToString(sum[:])
+}
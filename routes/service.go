@@ -3,33 +3,38 @@ package routes
 
 import (
   "errors"
+  "fmt"
   "github.com/gin-gonic/gin"
-  "github.com/go-redis/redis"
   "tezos-contests.izibi.com/backend/auth"
   "tezos-contests.izibi.com/backend/blocks"
   "tezos-contests.izibi.com/backend/config"
   "tezos-contests.izibi.com/backend/events"
   "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/pubsub"
   "tezos-contests.izibi.com/backend/utils"
 )
 
 type Service struct {
   config *config.Config
-  rc *redis.Client
+  pubsub pubsub.PubSub
+  cache pubsub.Store
   model *model.Model
   auth *auth.Service
   events *events.Service
   store *blocks.Service
+  jobs blockJobs
 }
 
-func NewService(config *config.Config, rc *redis.Client, model *model.Model, auth *auth.Service, events *events.Service, store *blocks.Service) *Service {
+func NewService(config *config.Config, backend pubsub.Backend, model *model.Model, auth *auth.Service, events *events.Service, store *blocks.Service) *Service {
   return &Service{
     config: config,
-    rc: rc,
+    pubsub: backend,
+    cache: backend,
     model: model,
     auth: auth,
     events: events,
     store: store,
+    jobs: blockJobs{running: make(map[string]bool), stop: make(chan struct{})},
   }
 }
 
@@ -37,8 +42,13 @@ func (svc *Service) RouteAll(r gin.IRoutes) {
   svc.RouteChains(r)
   svc.RouteContests(r)
   svc.RouteGames(r)
+  svc.RouteProposals(r)
+  svc.RouteReceipts(r)
   svc.RouteLanding(r)
+  svc.RouteMessages(r)
   svc.RouteTeams(r)
+  svc.RouteTeamKeys(r)
+  svc.RouteWebhooks(r)
 }
 
 func (svc *Service) signedRequest(c *gin.Context, req interface{}) (*utils.Response, error) {
@@ -48,8 +58,28 @@ func (svc *Service) signedRequest(c *gin.Context, req interface{}) (*utils.Respo
 }
 
 func (svc *Service) checkAuthor(author string) (int64, error) {
-  teamId, err := svc.model.FindTeamIdByKey(author[1:])
+  key, err := svc.checkAuthorKey(author)
   if err != nil { return 0, err }
-  if teamId == 0 { return 0, errors.New("team key is not recognized") }
-  return teamId, nil
+  return key.Team_id, nil
+}
+
+/* Resolves the author of a signed request to one of the active keys of
+   a team. */
+func (svc *Service) checkAuthorKey(author string) (*model.TeamKey, error) {
+  if len(author) == 0 { return nil, errors.New("team key is not recognized") }
+  key, err := svc.model.FindTeamKey(author[1:])
+  if err != nil { return nil, err }
+  if key == nil { return nil, errors.New("team key is not recognized") }
+  return key, nil
+}
+
+/* Charges the size of a new block to the team that caused its creation. */
+func (svc *Service) chargeBlock(teamId int64, hash string) {
+  size, err := svc.store.BlockSize(hash)
+  if err == nil {
+    err = svc.model.AddTeamStoreBytes(teamId, size)
+  }
+  if err != nil {
+    fmt.Printf("failed to charge block %s to team %d: %v\n", hash, teamId, err)
+  }
 }
@@ -0,0 +1,123 @@
+package routes
+
+import (
+  "time"
+  "github.com/gin-gonic/gin"
+  "tezos-contests.izibi.com/backend/auth"
+  "tezos-contests.izibi.com/backend/events"
+  j "tezos-contests.izibi.com/backend/jase"
+  "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/utils"
+  "tezos-contests.izibi.com/backend/view"
+)
+
+/* Number of actions returned by the game action log route. */
+const GameActionLogSize = 100
+
+func (svc *Service) RouteTeamKeys(r gin.IRoutes) {
+
+  r.GET("/Teams/:teamId/Keys", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    teamId, ok := svc.checkTeamMember(c, r)
+    if !ok { return }
+    keys, err := svc.model.LoadTeamKeys(teamId)
+    if err != nil { r.Error(err); return }
+    now := time.Now()
+    items := j.Array()
+    for i := range keys {
+      items.Item(ViewTeamKey(&keys[i], now))
+    }
+    r.Result(items)
+  })
+
+  r.POST("/Teams/:teamId/Keys", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    var req struct {
+      PublicKey string `json:"publicKey"`
+      Label string `json:"label"`
+      ExpiresAt *time.Time `json:"expiresAt"` /* RFC 3339, never expires if absent */
+    }
+    err := c.ShouldBindJSON(&req)
+    if err != nil { r.Error(err); return }
+    teamId := view.ImportId(c.Param("teamId"))
+    /* The key and the team's primary key are saved together. */
+    var key *model.TeamKey
+    err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+      key, err = tx.AddTeamKey(userId, teamId, req.PublicKey, req.Label, req.ExpiresAt)
+      return
+    })
+    if err != nil { r.Error(err); return }
+    svc.events.PostTeamEvent(teamId, events.TeamUpdatedEvent(view.ExportId(teamId)))
+    r.Result(ViewTeamKey(key, time.Now()))
+  })
+
+  r.POST("/TeamKeys/:keyId/Revoke", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    var key *model.TeamKey
+    err := svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+      key, err = tx.RevokeTeamKey(userId, view.ImportId(c.Param("keyId")))
+      return
+    })
+    if err != nil { r.Error(err); return }
+    svc.events.PostTeamEvent(key.Team_id, events.TeamUpdatedEvent(view.ExportId(key.Team_id)))
+    r.Result(ViewTeamKey(key, time.Now()))
+  })
+
+  /* Lists the game requests made by the team, with the key that signed
+     each of them. */
+  r.GET("/Teams/:teamId/GameActions", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    teamId, ok := svc.checkTeamMember(c, r)
+    if !ok { return }
+    actions, err := svc.model.LoadTeamGameActions(teamId, GameActionLogSize)
+    if err != nil { r.Error(err); return }
+    items := j.Array()
+    for i := range actions {
+      items.Item(ViewGameAction(&actions[i]))
+    }
+    r.Result(items)
+  })
+
+}
+
+/* Checks that the user is a member of the team in the request path. */
+func (svc *Service) checkTeamMember(c *gin.Context, r *utils.Response) (int64, bool) {
+  userId, ok := auth.GetUserId(c)
+  if !ok { r.BadUser(); return 0, false }
+  teamId := view.ImportId(c.Param("teamId"))
+  isMember, err := svc.model.IsUserInTeam(userId, teamId)
+  if err != nil { r.Error(err); return 0, false }
+  if !isMember { r.StringError("forbidden"); return 0, false }
+  return teamId, true
+}
+
+func ViewTeamKey(key *model.TeamKey, now time.Time) j.Value {
+  obj := j.Object()
+  obj.Prop("id", j.String(view.ExportId(key.Id)))
+  obj.Prop("createdAt", j.Time(key.Created_at))
+  obj.Prop("teamId", j.String(view.ExportId(key.Team_id)))
+  obj.Prop("publicKey", j.String(key.Public_key))
+  obj.Prop("label", j.String(key.Label))
+  if key.Expires_at.Valid {
+    obj.Prop("expiresAt", j.Time(key.Expires_at.Time))
+  }
+  if key.Revoked_at.Valid {
+    obj.Prop("revokedAt", j.Time(key.Revoked_at.Time))
+  }
+  obj.Prop("isActive", j.Boolean(key.IsActive(now)))
+  return obj
+}
+
+func ViewGameAction(action *model.GameAction) j.Value {
+  obj := j.Object()
+  obj.Prop("id", j.String(view.ExportId(action.Id)))
+  obj.Prop("createdAt", j.Time(action.Created_at))
+  obj.Prop("gameKey", j.String(action.Game_key))
+  obj.Prop("keyId", j.String(view.ExportId(action.Team_key_id)))
+  obj.Prop("action", j.String(action.Action))
+  return obj
+}
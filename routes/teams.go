@@ -4,6 +4,7 @@ package routes
 import (
   "github.com/gin-gonic/gin"
   "tezos-contests.izibi.com/backend/auth"
+  "tezos-contests.izibi.com/backend/events"
   "tezos-contests.izibi.com/backend/model"
   "tezos-contests.izibi.com/backend/view"
   "tezos-contests.izibi.com/backend/utils"
@@ -19,6 +20,7 @@ func (svc *Service) RouteTeams(r gin.IRoutes) {
     teamId := view.ImportId(c.Param("teamId"))
     err = svc.model.LeaveTeam(teamId, userId)
     if err != nil { r.Error(err); return }
+    svc.events.PostTeamEvent(teamId, events.TeamUpdatedEvent(view.ExportId(teamId)))
     r.Ok()
   })
 
@@ -32,6 +34,7 @@ func (svc *Service) RouteTeams(r gin.IRoutes) {
     var team *model.Team
     team, err = svc.model.RenewTeamAccessCode(teamId, userId)
     if err != nil { r.Error(err); return }
+    svc.events.PostTeamEvent(team.Id, events.TeamUpdatedEvent(view.ExportId(team.Id)))
     err = v.ViewUserContestTeam(userId, team.Contest_id)
     if err != nil { r.Error(err); return }
     r.Send(v.Flat())
@@ -48,8 +51,12 @@ func (svc *Service) RouteTeams(r gin.IRoutes) {
     err = c.ShouldBindJSON(&arg)
     if err != nil { r.Error(err); return }
     var team *model.Team
-    team, err = svc.model.UpdateTeam(teamId, userId, arg)
+    err = svc.model.Transaction(c.Request.Context(), func (tx *model.Tx) (err error) {
+      team, err = tx.UpdateTeam(teamId, userId, arg)
+      return
+    })
     if err != nil { r.Error(err); return }
+    svc.events.PostTeamEvent(team.Id, events.TeamUpdatedEvent(view.ExportId(team.Id)))
     err = v.ViewUserContestTeam(userId, team.Contest_id)
     if err != nil { r.Error(err); return }
     r.Send(v.Flat())
@@ -0,0 +1,122 @@
+
+package routes
+
+import (
+  "strings"
+  "github.com/gin-gonic/gin"
+  "tezos-contests.izibi.com/backend/auth"
+  j "tezos-contests.izibi.com/backend/jase"
+  "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/utils"
+  "tezos-contests.izibi.com/backend/view"
+)
+
+/* Number of deliveries returned by the delivery log route. */
+const WebhookDeliveryLogSize = 50
+
+func (svc *Service) RouteWebhooks(r gin.IRoutes) {
+
+  r.GET("/Teams/:teamId/Webhooks", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    teamId := view.ImportId(c.Param("teamId"))
+    isMember, err := svc.model.IsUserInTeam(userId, teamId)
+    if err != nil { r.Error(err); return }
+    if !isMember { r.StringError("forbidden"); return }
+    hooks, err := svc.model.LoadTeamWebhooks(teamId)
+    if err != nil { r.Error(err); return }
+    items := j.Array()
+    for i := range hooks {
+      items.Item(ViewWebhook(&hooks[i], false))
+    }
+    r.Result(items)
+  })
+
+  /* The secret is only returned when the webhook is created. */
+  r.POST("/Teams/:teamId/Webhooks", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    var req struct {
+      Url string `json:"url"`
+      EventTypes []string `json:"eventTypes"` /* all event types if empty */
+    }
+    err := c.ShouldBindJSON(&req)
+    if err != nil { r.Error(err); return }
+    teamId := view.ImportId(c.Param("teamId"))
+    hook, err := svc.model.CreateTeamWebhook(userId, teamId, req.Url, req.EventTypes)
+    if err != nil { r.Error(err); return }
+    r.Result(ViewWebhook(hook, true))
+  })
+
+  r.POST("/Webhooks/:webhookId/Delete", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    err := svc.model.DeleteTeamWebhook(userId, view.ImportId(c.Param("webhookId")))
+    if err != nil { r.Error(err); return }
+    r.Ok()
+  })
+
+  r.GET("/Webhooks/:webhookId/Deliveries", func(c *gin.Context) {
+    r := utils.NewResponse(c)
+    userId, ok := auth.GetUserId(c)
+    if !ok { r.BadUser(); return }
+    hook, err := svc.model.LoadTeamWebhook(view.ImportId(c.Param("webhookId")))
+    if err != nil { r.Error(err); return }
+    isMember, err := svc.model.IsUserInTeam(userId, hook.Team_id)
+    if err != nil { r.Error(err); return }
+    if !isMember { r.StringError("forbidden"); return }
+    deliveries, err := svc.model.LoadWebhookDeliveries(hook.Id, WebhookDeliveryLogSize)
+    if err != nil { r.Error(err); return }
+    items := j.Array()
+    for i := range deliveries {
+      items.Item(ViewWebhookDelivery(&deliveries[i]))
+    }
+    r.Result(items)
+  })
+
+}
+
+func ViewWebhook(hook *model.TeamWebhook, withSecret bool) j.Value {
+  obj := j.Object()
+  obj.Prop("id", j.String(view.ExportId(hook.Id)))
+  obj.Prop("createdAt", j.Time(hook.Created_at))
+  obj.Prop("teamId", j.String(view.ExportId(hook.Team_id)))
+  obj.Prop("url", j.String(hook.Url))
+  eventTypes := j.Array()
+  if hook.Event_types != "" {
+    for _, typ := range strings.Split(hook.Event_types, ",") {
+      eventTypes.Item(j.String(typ))
+    }
+  }
+  obj.Prop("eventTypes", eventTypes)
+  obj.Prop("isActive", j.Boolean(hook.Is_active))
+  if withSecret {
+    obj.Prop("secret", j.String(hook.Secret))
+  }
+  return obj
+}
+
+func ViewWebhookDelivery(delivery *model.WebhookDelivery) j.Value {
+  obj := j.Object()
+  obj.Prop("id", j.String(view.ExportId(delivery.Id)))
+  obj.Prop("createdAt", j.Time(delivery.Created_at))
+  obj.Prop("eventType", j.String(delivery.Event_type))
+  obj.Prop("status", j.String(delivery.Status))
+  obj.Prop("nbAttempts", j.Int(delivery.Nb_attempts))
+  if delivery.Last_attempt_at.Valid {
+    obj.Prop("lastAttemptAt", j.Time(delivery.Last_attempt_at.Time))
+  }
+  if delivery.Next_attempt_at.Valid {
+    obj.Prop("nextAttemptAt", j.Time(delivery.Next_attempt_at.Time))
+  }
+  if delivery.Last_status_code.Valid {
+    obj.Prop("lastStatusCode", j.Int64(delivery.Last_status_code.Int64))
+  }
+  if delivery.Last_error != "" {
+    obj.Prop("lastError", j.String(delivery.Last_error))
+  }
+  return obj
+}
@@ -0,0 +1,98 @@
+package signing
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "math/big"
+  "github.com/go-errors/errors"
+  "golang.org/x/crypto/ed25519"
+)
+
+/*
+  A signature algorithm.  Keys are wrapped as "<base64>.<algorithm name>",
+  and the algorithm of a signature must match that of the author's key.
+*/
+type Algorithm interface {
+  GenerateKey() (pub []byte, pri []byte, err error)
+  Sign(pri []byte, hash []byte) ([]byte, error)
+  Verify(pub []byte, hash []byte, sig []byte) bool
+}
+
+var algorithms = map[string]Algorithm{
+  "ed25519": ed25519Algorithm{},
+  "ecdsa-p256": ecdsaP256Algorithm{},
+}
+
+func RegisterAlgorithm(name string, alg Algorithm) {
+  algorithms[name] = alg
+}
+
+func getAlgorithm(name string) (Algorithm, error) {
+  alg, ok := algorithms[name]
+  if !ok { return nil, errors.Errorf("unsupported signature algorithm %q", name) }
+  return alg, nil
+}
+
+type ed25519Algorithm struct {}
+
+func (ed25519Algorithm) GenerateKey() ([]byte, []byte, error) {
+  pub, pri, err := ed25519.GenerateKey(rand.Reader)
+  if err != nil { return nil, nil, err }
+  return pub, pri, nil
+}
+
+func (ed25519Algorithm) Sign(pri []byte, hash []byte) ([]byte, error) {
+  if len(pri) != ed25519.PrivateKeySize { return nil, errors.New("bad private key") }
+  return ed25519.Sign(pri, hash), nil
+}
+
+func (ed25519Algorithm) Verify(pub []byte, hash []byte, sig []byte) bool {
+  if len(pub) != ed25519.PublicKeySize { return false }
+  return ed25519.Verify(pub, hash, sig)
+}
+
+/* ECDSA on P-256: public keys are uncompressed points, private keys are
+   32-byte scalars and signatures are r || s (32 bytes each). */
+type ecdsaP256Algorithm struct {}
+
+func (ecdsaP256Algorithm) GenerateKey() ([]byte, []byte, error) {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil { return nil, nil, err }
+  pub := elliptic.Marshal(elliptic.P256(), key.X, key.Y)
+  pri := make([]byte, 32)
+  putScalar(pri, key.D)
+  return pub, pri, nil
+}
+
+func (ecdsaP256Algorithm) Sign(pri []byte, hash []byte) ([]byte, error) {
+  if len(pri) != 32 { return nil, errors.New("bad private key") }
+  curve := elliptic.P256()
+  key := new(ecdsa.PrivateKey)
+  key.Curve = curve
+  key.D = new(big.Int).SetBytes(pri)
+  key.X, key.Y = curve.ScalarBaseMult(pri)
+  r, s, err := ecdsa.Sign(rand.Reader, key, hash)
+  if err != nil { return nil, errors.Wrap(err, 0) }
+  sig := make([]byte, 64)
+  putScalar(sig[:32], r)
+  putScalar(sig[32:], s)
+  return sig, nil
+}
+
+func (ecdsaP256Algorithm) Verify(pub []byte, hash []byte, sig []byte) bool {
+  if len(sig) != 64 { return false }
+  curve := elliptic.P256()
+  x, y := elliptic.Unmarshal(curve, pub)
+  if x == nil { return false }
+  key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+  r := new(big.Int).SetBytes(sig[:32])
+  s := new(big.Int).SetBytes(sig[32:])
+  return ecdsa.Verify(key, hash, r, s)
+}
+
+/* Writes n big-endian into dst, left-padded with zeroes. */
+func putScalar(dst []byte, n *big.Int) {
+  b := n.Bytes()
+  copy(dst[len(dst) - len(b):], b)
+}
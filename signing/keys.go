@@ -4,10 +4,9 @@ package signing
 import (
   "io"
   "strings"
-  "crypto/rand"
   "encoding/base64"
   "encoding/json"
-  "golang.org/x/crypto/ed25519"
+  "github.com/go-errors/errors"
   j "tezos-contests.izibi.com/backend/jase"
 )
 
@@ -18,17 +17,23 @@ type KeyPair struct {
 }
 
 func NewKeyPair () (*KeyPair, error) {
-  pub, pri, err := ed25519.GenerateKey(rand.Reader)
+  return NewKeyPairWith("ed25519")
+}
+
+func NewKeyPairWith (algName string) (*KeyPair, error) {
+  alg, err := getAlgorithm(algName)
+  if err != nil { return nil, err }
+  pub, pri, err := alg.GenerateKey()
   if err != nil { return nil, err }
   return &KeyPair{
-    Curve: "ed25519",
-    Public: wrapKey(pub),
-    Private: wrapKey(pri),
+    Curve: algName,
+    Public: wrapKey(pub, algName),
+    Private: wrapKey(pri, algName),
   }, nil
 }
 
-func wrapKey(key []byte) string {
-  return base64.StdEncoding.EncodeToString(key) + ".ed25519"
+func wrapKey(key []byte, algName string) string {
+  return base64.StdEncoding.EncodeToString(key) + "." + algName
 }
 
 func unwrapKey(key string) ([]byte, error) {
@@ -36,6 +41,23 @@ func unwrapKey(key string) ([]byte, error) {
   return base64.StdEncoding.DecodeString(b64)
 }
 
+/* Checks that a wrapped public key is well-formed and uses a supported
+   algorithm. */
+func CheckPublicKey(key string) error {
+  _, err := getAlgorithm(keyAlgorithm(key))
+  if err != nil { return err }
+  raw, err := unwrapKey(key)
+  if err != nil || len(raw) == 0 { return errors.New("bad public key") }
+  return nil
+}
+
+/* Name of the algorithm of a wrapped key. */
+func keyAlgorithm(key string) string {
+  i := strings.Index(key, ".")
+  if i == -1 { return "" }
+  return key[i+1:]
+}
+
 func ReadKeyPair (r io.Reader) (*KeyPair, error) {
   var res KeyPair
   err := json.NewDecoder(r).Decode(&res)
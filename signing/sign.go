@@ -1,21 +1,71 @@
-
 package signing
 
 import (
   "bytes"
   "fmt"
   "encoding/base64"
+  "encoding/json"
+  "strings"
   "golang.org/x/crypto/ed25519"
   "github.com/go-errors/errors"
   j "tezos-contests.izibi.com/backend/jase"
 )
 
+/*
+  Signed messages are JSON objects with an "author" property holding the
+  signer's public key (prefixed with '@') and a "signature" property.
+
+  Version 2 (current): the signature is an object
+
+    {"version": 2, "algorithm": "ed25519", "value": "<base64>"}
+
+  The signed hash covers the RFC 8785 canonical JSON (see jase.JCS) of the
+  whole message, in which the signature has no "value" property; the
+  version and algorithm are thus covered by the signature.  The layout and
+  key order of the message sent do not matter.  As in RFC 8785, numbers
+  are IEEE 754 doubles: integers that need more than 53 bits should be
+  sent as strings.
+
+  Version 1 (legacy): the signature is a string "<base64>.sig.ed25519",
+  computed over the message as formatted by jase.PrettyBytes, and must be
+  its last property.  It is still accepted by Verify.
+*/
+const SignatureVersion = 2
+
+/* Signs a message with the current scheme, using the algorithm of the
+   private key.  The signed message is returned in canonical form. */
 func Sign(privKey string, apiKey string, message []byte) ([]byte, error) {
+  var err error
+  alg, err := getAlgorithm(keyAlgorithm(privKey))
+  if err != nil { return nil, err }
+  pri, err := unwrapKey(privKey)
+  if err != nil { return nil, errors.New("bad private key") }
+  doc, err := decodeMessage(message)
+  if err != nil { return nil, err }
+  sigObj := map[string]interface{}{
+    "version": json.Number(fmt.Sprintf("%d", SignatureVersion)),
+    "algorithm": keyAlgorithm(privKey),
+  }
+  doc["signature"] = sigObj
+  canonical, err := canonicalBytes(doc)
+  if err != nil { return nil, err }
+  rawApiKey, _ := base64.StdEncoding.DecodeString(apiKey)
+  hash := hashMessage(rawApiKey, canonical)
+  rawSig, err := alg.Sign(pri, hash)
+  if err != nil { return nil, err }
+  sigObj["value"] = base64.StdEncoding.EncodeToString(rawSig)
+  return canonicalBytes(doc)
+}
+
+/* Signs a message with the version 1 scheme (ed25519 keys only). */
+func SignLegacy(privKey string, apiKey string, message []byte) ([]byte, error) {
   var err error
   message, err = j.PrettyBytes(message)
   if err != nil { return nil, errors.WrapPrefix(err, "bad message", 0) }
   pri, err := unwrapKey(privKey)
-  if err != nil { return nil, errors.New("bad private key") }
+  if err != nil || len(pri) != ed25519.PrivateKeySize {
+    return nil, errors.New("bad private key")
+  }
   rawApiKey, _ := base64.StdEncoding.DecodeString(apiKey)
   hash := hashMessage(rawApiKey, message)
   rawSig := ed25519.Sign(pri, hash)
@@ -30,3 +80,63 @@ func injectSignature(message []byte, sig string) []byte {
   fmt.Fprintf(out, ",\n  %q: %q\n}", "signature", sig)
   return out.Bytes()
 }
+
+/* Decodes a message, rejecting duplicate property names. */
+func decodeMessage(message []byte) (map[string]interface{}, error) {
+  err := checkDuplicateKeys(message)
+  if err != nil { return nil, err }
+  dec := json.NewDecoder(bytes.NewReader(message))
+  dec.UseNumber()
+  var doc map[string]interface{}
+  err = dec.Decode(&doc)
+  if err != nil || doc == nil { return nil, errors.New("bad message") }
+  return doc, nil
+}
+
+/*
+  Decoders disagree on which of duplicate properties wins (encoding/json
+  keeps the last one), so a message with duplicates could be verified
+  with one author and acted upon with another.  Names are compared
+  case-insensitively, as encoding/json matches them to struct fields.
+*/
+func checkDuplicateKeys(message []byte) error {
+  dec := json.NewDecoder(bytes.NewReader(message))
+  err := checkValueKeys(dec)
+  if err != nil { return errors.WrapPrefix(err, "bad message", 0) }
+  return nil
+}
+
+func checkValueKeys(dec *json.Decoder) error {
+  tok, err := dec.Token()
+  if err != nil { return err }
+  switch tok {
+  case json.Delim('{'):
+    seen := make(map[string]bool)
+    for dec.More() {
+      tok, err = dec.Token()
+      if err != nil { return err }
+      key := strings.ToLower(strings.ToUpper(tok.(string)))
+      if seen[key] { return errors.Errorf("duplicate property %q", tok) }
+      seen[key] = true
+      err = checkValueKeys(dec)
+      if err != nil { return err }
+    }
+  case json.Delim('['):
+    for dec.More() {
+      err = checkValueKeys(dec)
+      if err != nil { return err }
+    }
+  default:
+    return nil
+  }
+  _, err = dec.Token() /* closing delimiter */
+  return err
+}
+
+func canonicalBytes(doc map[string]interface{}) ([]byte, error) {
+  bs, err := json.Marshal(doc)
+  if err != nil { return nil, errors.WrapPrefix(err, "bad message", 0) }
+  bs, err = j.JCS(bs)
+  if err != nil { return nil, errors.WrapPrefix(err, "bad message", 0) }
+  return bs, nil
+}
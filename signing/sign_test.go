@@ -0,0 +1,76 @@
+package signing
+
+import (
+  "bytes"
+  "encoding/base64"
+  "fmt"
+  "testing"
+)
+
+var testApiKey = base64.StdEncoding.EncodeToString([]byte("api key"))
+
+func testMessage(t *testing.T, algName string) (*KeyPair, []byte) {
+  keyPair, err := NewKeyPairWith(algName)
+  if err != nil { t.Fatal(err) }
+  message := fmt.Sprintf(`{"author": "@%s", "action": "register", "ranks": [1, 2]}`, keyPair.Public)
+  return keyPair, []byte(message)
+}
+
+func TestSignVerify(t *testing.T) {
+  for _, algName := range []string{"ed25519", "ecdsa-p256"} {
+    keyPair, message := testMessage(t, algName)
+    signed, err := Sign(keyPair.Private, testApiKey, message)
+    if err != nil { t.Fatalf("%s: %v", algName, err) }
+    if err := Verify(testApiKey, signed); err != nil {
+      t.Errorf("%s: %v", algName, err)
+    }
+    otherKey := base64.StdEncoding.EncodeToString([]byte("other key"))
+    if Verify(otherKey, signed) == nil {
+      t.Errorf("%s: signature verified with another api key", algName)
+    }
+    tampered := bytes.Replace(signed, []byte(`"register"`), []byte(`"unregister"`), 1)
+    if Verify(testApiKey, tampered) == nil {
+      t.Errorf("%s: tampered message verified", algName)
+    }
+  }
+}
+
+func TestSignVerifyLegacy(t *testing.T) {
+  keyPair, message := testMessage(t, "ed25519")
+  signed, err := SignLegacy(keyPair.Private, testApiKey, message)
+  if err != nil { t.Fatal(err) }
+  if err := Verify(testApiKey, signed); err != nil { t.Error(err) }
+  tampered := bytes.Replace(signed, []byte(`"register"`), []byte(`"unregister"`), 1)
+  if Verify(testApiKey, tampered) == nil { t.Error("tampered message verified") }
+  ecdsaKeyPair, _ := testMessage(t, "ecdsa-p256")
+  if _, err := SignLegacy(ecdsaKeyPair.Private, testApiKey, message); err == nil {
+    t.Error("legacy signature with an ecdsa key")
+  }
+}
+
+func TestVerifyDuplicateAuthor(t *testing.T) {
+  keyPair, _ := testMessage(t, "ed25519")
+  _, victim := testMessage(t, "ed25519")
+  /* Key A signs a message whose author is B, then puts itself as the
+     first author: the signature is checked against A's key, and the
+     message must not be decoded with author B. */
+  signed, err := Sign(keyPair.Private, testApiKey, victim)
+  if err != nil { t.Fatal(err) }
+  legacy, err := SignLegacy(keyPair.Private, testApiKey, victim)
+  if err != nil { t.Fatal(err) }
+  for _, name := range []string{"author", "Author", "AUTHOR"} {
+    prop := fmt.Sprintf(`%q: "@%s"`, name, keyPair.Public)
+    doc := []byte("{" + prop + "," + string(signed[1:]))
+    if Verify(testApiKey, doc) == nil {
+      t.Errorf("duplicate %q verified", name)
+    }
+    doc = []byte("{" + prop + "," + string(legacy[1:]))
+    if Verify(testApiKey, doc) == nil {
+      t.Errorf("duplicate %q in a legacy message verified", name)
+    }
+  }
+  nested := fmt.Sprintf(`{"author": "@%s", "data": {"a": 1, "a": 2}}`, keyPair.Public)
+  if _, err := Sign(keyPair.Private, testApiKey, []byte(nested)); err == nil {
+    t.Error("message with a nested duplicate signed")
+  }
+}
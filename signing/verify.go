@@ -1,19 +1,64 @@
-
 package signing
 
 import (
   "bytes"
   "encoding/base64"
+  "encoding/json"
   "golang.org/x/crypto/ed25519"
-  "github.com/json-iterator/go"
   "github.com/go-errors/errors"
   j "tezos-contests.izibi.com/backend/jase"
 )
 
+/* Verifies a message signed with either version of the scheme (see
+   sign.go).  Messages with duplicate property names are rejected, so that
+   the author whose key is checked is the author seen by the decoders of
+   the message. */
 func Verify(apiKey string, message []byte) error {
-  pub, err := extractAuthorKey(message)
-  if err != nil { return err }
   rawApiKey, _ :=  base64.StdEncoding.DecodeString(apiKey)
+  doc, err := decodeMessage(message)
+  if err != nil { return err }
+  author, err := extractAuthor(doc)
+  if err != nil { return err }
+  switch doc["signature"].(type) {
+  case map[string]interface{}:
+    return verifyCanonical(rawApiKey, doc, author)
+  case string:
+    return verifyLegacy(rawApiKey, message, author)
+  }
+  return errors.New("signature not found")
+}
+
+func verifyCanonical(rawApiKey []byte, doc map[string]interface{}, author string) error {
+  var err error
+  sigObj := doc["signature"].(map[string]interface{})
+  if version, ok := sigObj["version"].(json.Number); !ok || version.String() != "2" {
+    return errors.New("unsupported signature version")
+  }
+  algName, _ := sigObj["algorithm"].(string)
+  if algName != keyAlgorithm(author) {
+    return errors.New("signature algorithm does not match the author's key")
+  }
+  alg, err := getAlgorithm(algName)
+  if err != nil { return err }
+  value, _ := sigObj["value"].(string)
+  sig, err := base64.StdEncoding.DecodeString(value)
+  if err != nil || len(sig) == 0 { return errors.New("bad signature") }
+  delete(sigObj, "value")
+  pub, err := unwrapKey(author)
+  if err != nil { return errors.New("bad public key") }
+  canonical, err := canonicalBytes(doc)
+  if err != nil { return err }
+  hash := hashMessage(rawApiKey, canonical)
+  if !alg.Verify(pub, hash, sig) { return errors.New("bad signature") }
+  return nil
+}
+
+func verifyLegacy(rawApiKey []byte, message []byte, author string) error {
+  if keyAlgorithm(author) != "ed25519" { return errors.New("bad public key") }
+  pub, err := unwrapKey(author)
+  if err != nil || len(pub) != ed25519.PublicKeySize {
+    return errors.New("bad public key")
+  }
   message, err = j.PrettyBytes(message)
   if err != nil { return errors.WrapPrefix(err, "bad message", 0) }
   message, sig := extractSignature(message)
@@ -23,27 +68,28 @@ func Verify(apiKey string, message []byte) error {
   return nil
 }
 
-func extractAuthorKey(message []byte) ([]byte, error) {
-  author := jsoniter.Get(message, "author").ToString()
+/* Returns the author's wrapped public key, without the '@' prefix. */
+func extractAuthor(doc map[string]interface{}) (string, error) {
+  author, _ := doc["author"].(string)
   if len(author) == 0 || author[0] != '@' {
-    return nil, errors.New("bad author")
+    return "", errors.New("bad author")
   }
-  pub, err := unwrapKey(author[1:])
-  if err != nil {
-    return nil, errors.New("bad public key")
-  }
-  return pub, nil
+  return author[1:], nil
 }
 
+/* Splits a legacy signed message (formatted by jase.PrettyBytes) into the
+   bare message and the raw signature, which must be its last property. */
 func extractSignature(msg []byte) (bare []byte, sig []byte) {
-  out := new(bytes.Buffer)
-  l := len(msg)
-  if string(msg[l-121:l-103]) != ",\n  \"signature\": \"" { return msg, nil }
-  if string(msg[l-15:]) != ".sig.ed25519\"\n}" { return msg, nil }
-  out.Write(msg[:l-121])
-  out.Write([]byte("\n}"))
-  b64Sig := string(msg[l-103:l-15])
+  const sigPrefix = ",\n  \"signature\": \""
+  const sigSuffix = ".sig.ed25519\"\n}"
+  if !bytes.HasSuffix(msg, []byte(sigSuffix)) { return msg, nil }
+  i := bytes.LastIndex(msg, []byte(sigPrefix))
+  if i == -1 { return msg, nil }
+  b64Sig := string(msg[i + len(sigPrefix):len(msg) - len(sigSuffix)])
   rawSig, err := base64.StdEncoding.DecodeString(b64Sig)
   if err != nil { return msg, nil }
+  out := new(bytes.Buffer)
+  out.Write(msg[:i])
+  out.Write([]byte("\n}"))
   return out.Bytes(), rawSig
 }
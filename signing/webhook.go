@@ -0,0 +1,19 @@
+
+package signing
+
+import (
+  "encoding/hex"
+)
+
+/*
+  Signature of a webhook delivery: the HMAC (as in hashMessage) of the
+  timestamp and body, keyed with the webhook's secret.  Receivers should
+  check the timestamp to reject replayed deliveries.
+*/
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+  message := make([]byte, 0, len(timestamp) + 1 + len(body))
+  message = append(message, timestamp...)
+  message = append(message, '.')
+  message = append(message, body...)
+  return hex.EncodeToString(hashMessage([]byte(secret), message))
+}
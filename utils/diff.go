@@ -0,0 +1,242 @@
+package utils
+
+import (
+  "bytes"
+  "fmt"
+  "strings"
+)
+
+/* Number of unchanged lines shown around each change in a unified diff. */
+const DiffContextLines = 3
+
+/* Texts with more lines than this in total are not compared line by line. */
+const DiffMaxLines = 10000
+
+type Diff struct {
+  Text string /* unified diff, empty if both sides are identical */
+  Added int
+  Removed int
+  Hunks int
+}
+
+type diffOp struct {
+  kind byte /* ' ', '-', '+' */
+  text string
+  aPos int /* index of the line in a (or insertion point) */
+  bPos int /* index of the line in b (or deletion point) */
+}
+
+/* UnifiedDiff compares two texts line by line and returns a diff in the
+   format produced by `diff -u`, along with summary statistics. */
+func UnifiedDiff(aName string, a string, bName string, b string) *Diff {
+  ops := diffLines(splitLines(a), splitLines(b))
+  var res Diff
+  var buf bytes.Buffer
+  i := 0
+  for i < len(ops) {
+    /* Skip to the next change. */
+    for i < len(ops) && ops[i].kind == ' ' { i++ }
+    if i == len(ops) { break }
+    start := i - DiffContextLines
+    if start < 0 { start = 0 }
+    /* Extend the hunk while the changes are separated by less than
+       twice the context. */
+    end := i
+    for {
+      for end < len(ops) && ops[end].kind != ' ' { end++ }
+      next := end
+      for next < len(ops) && ops[next].kind == ' ' { next++ }
+      if next == len(ops) || next - end > 2 * DiffContextLines { break }
+      end = next
+    }
+    stop := end + DiffContextLines
+    if stop > len(ops) { stop = len(ops) }
+    if res.Hunks == 0 {
+      fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+    }
+    writeHunk(&buf, ops[start:stop], &res)
+    i = stop
+  }
+  res.Text = buf.String()
+  return &res
+}
+
+func writeHunk(buf *bytes.Buffer, ops []diffOp, res *Diff) {
+  var aLen, bLen int
+  for _, op := range ops {
+    if op.kind != '+' { aLen++ }
+    if op.kind != '-' { bLen++ }
+  }
+  fmt.Fprintf(buf, "@@ -%s +%s @@\n",
+    hunkRange(ops[0].aPos, aLen), hunkRange(ops[0].bPos, bLen))
+  for _, op := range ops {
+    buf.WriteByte(op.kind)
+    buf.WriteString(op.text)
+    buf.WriteByte('\n')
+    switch op.kind {
+    case '+':
+      res.Added++
+    case '-':
+      res.Removed++
+    }
+  }
+  res.Hunks++
+}
+
+func hunkRange(pos int, length int) string {
+  /* An empty range refers to the line preceding it. */
+  if length == 0 {
+    return fmt.Sprintf("%d,0", pos)
+  }
+  if length == 1 {
+    return fmt.Sprintf("%d", pos + 1)
+  }
+  return fmt.Sprintf("%d,%d", pos + 1, length)
+}
+
+func splitLines(s string) []string {
+  if s == "" { return nil }
+  lines := strings.Split(s, "\n")
+  if lines[len(lines) - 1] == "" {
+    lines = lines[:len(lines) - 1]
+  }
+  return lines
+}
+
+/*
+  diffLines computes a shortest edit script using the linear space variant
+  of Myers' algorithm: the middle snake of an optimal path is found by
+  searching from both ends, and the parts before and after it are compared
+  recursively.  Memory use is O(N+M) and time O((N+M)D).  Texts of more than
+  DiffMaxLines lines in total are shown as entirely replaced.
+*/
+func diffLines(a []string, b []string) []diffOp {
+  d := lineDiffer{a: a, b: b}
+  if len(a) + len(b) <= DiffMaxLines {
+    d.compare(0, len(a), 0, len(b))
+  }
+  /* Between two matching lines, show the removed lines first. */
+  var ops []diffOp
+  x, y := 0, 0
+  for _, match := range append(d.matches, [2]int{len(a), len(b)}) {
+    for ; x < match[0]; x++ {
+      ops = append(ops, diffOp{'-', a[x], x, y})
+    }
+    for ; y < match[1]; y++ {
+      ops = append(ops, diffOp{'+', b[y], x, y})
+    }
+    if x < len(a) {
+      ops = append(ops, diffOp{' ', a[x], x, y})
+      x++
+      y++
+    }
+  }
+  return ops
+}
+
+type lineDiffer struct {
+  a []string
+  b []string
+  matches [][2]int /* pairs of matching line indices, in order */
+}
+
+func (d *lineDiffer) compare(aLo int, aHi int, bLo int, bHi int) {
+  for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+    d.matches = append(d.matches, [2]int{aLo, bLo})
+    aLo++
+    bLo++
+  }
+  aEnd := aHi
+  for aLo < aHi && bLo < bHi && d.a[aHi - 1] == d.b[bHi - 1] {
+    aHi--
+    bHi--
+  }
+  if aLo < aHi && bLo < bHi {
+    x, y := d.bisect(aLo, aHi, bLo, bHi)
+    d.compare(aLo, x, bLo, y)
+    d.compare(x, aHi, y, bHi)
+  }
+  for x, y := aHi, bHi; x < aEnd; x, y = x + 1, y + 1 {
+    d.matches = append(d.matches, [2]int{x, y})
+  }
+}
+
+/* Returns a point of an optimal path from (aLo, bLo) to (aHi, bHi) at which
+   the comparison is split, found where the paths of length D searched
+   forwards from the start and backwards from the end first overlap. */
+func (d *lineDiffer) bisect(aLo int, aHi int, bLo int, bHi int) (int, int) {
+  a, b := d.a[aLo:aHi], d.b[bLo:bHi]
+  n, m := len(a), len(b)
+  maxD := (n + m + 1) / 2
+  offset := maxD
+  /* vf[offset + k] is the furthest x reached on diagonal k = x - y going
+     forwards, vb[offset + k] the furthest distance from the end going
+     backwards (on reversed texts), or -1. */
+  vf := make([]int, 2 * maxD + 2)
+  vb := make([]int, 2 * maxD + 2)
+  for i := range vf {
+    vf[i] = -1
+    vb[i] = -1
+  }
+  vf[offset + 1] = 0
+  vb[offset + 1] = 0
+  delta := n - m
+  /* The paths overlap after a forward step if delta is odd, after
+     a backward step otherwise. */
+  front := delta % 2 != 0
+  /* Diagonals that ran off the edges of the grid are not searched again. */
+  fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+  for D := 0; D < maxD; D++ {
+    for k := -D + fStart; k <= D - fEnd; k += 2 {
+      var x int
+      if k == -D || (k != D && vf[offset + k - 1] < vf[offset + k + 1]) {
+        x = vf[offset + k + 1]
+      } else {
+        x = vf[offset + k - 1] + 1
+      }
+      y := x - k
+      for x < n && y < m && a[x] == b[y] {
+        x++
+        y++
+      }
+      vf[offset + k] = x
+      if x > n {
+        fEnd += 2
+      } else if y > m {
+        fStart += 2
+      } else if front {
+        bk := offset + delta - k
+        if bk >= 0 && bk < len(vb) && vb[bk] != -1 && x >= n - vb[bk] {
+          return aLo + x, bLo + y
+        }
+      }
+    }
+    for k := -D + bStart; k <= D - bEnd; k += 2 {
+      var x int
+      if k == -D || (k != D && vb[offset + k - 1] < vb[offset + k + 1]) {
+        x = vb[offset + k + 1]
+      } else {
+        x = vb[offset + k - 1] + 1
+      }
+      y := x - k
+      for x < n && y < m && a[n - x - 1] == b[m - y - 1] {
+        x++
+        y++
+      }
+      vb[offset + k] = x
+      if x > n {
+        bEnd += 2
+      } else if y > m {
+        bStart += 2
+      } else if !front {
+        fk := offset + delta - k
+        if fk >= 0 && fk < len(vf) && vf[fk] != -1 && vf[fk] >= n - x {
+          fx := vf[fk]
+          return aLo + fx, bLo + fx - (delta - k)
+        }
+      }
+    }
+  }
+  /* No line in common. */
+  return aHi, bLo
+}
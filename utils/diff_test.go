@@ -0,0 +1,76 @@
+package utils
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+)
+
+/* Expected outputs are those of GNU diff -u. */
+func TestUnifiedDiff(t *testing.T) {
+  letters := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+  cases := []struct {
+    a, b string
+    text string
+    added, removed, hunks int
+  }{
+    {letters, letters, "", 0, 0, 0},
+    {"", "", "", 0, 0, 0},
+    {
+      letters, strings.Replace(letters, "b\n", "B\n", 1) + "n\n",
+      "--- old\n+++ new\n" +
+      "@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+      "@@ -11,3 +11,4 @@\n k\n l\n m\n+n\n",
+      2, 1, 2,
+    },
+    {
+      /* Changes separated by less than twice the context share a hunk. */
+      letters, strings.Replace(strings.Replace(letters, "b\n", "", 1), "h\n", "H\n", 1),
+      "--- old\n+++ new\n" +
+      "@@ -1,11 +1,10 @@\n a\n-b\n c\n d\n e\n f\n g\n-h\n+H\n i\n j\n k\n",
+      1, 2, 1,
+    },
+    {"x\na\nb\n", "a\nb\n", "--- old\n+++ new\n@@ -1,3 +1,2 @@\n-x\n a\n b\n", 0, 1, 1},
+    {"a\nb\n", "x\na\nb\n", "--- old\n+++ new\n@@ -1,2 +1,3 @@\n+x\n a\n b\n", 1, 0, 1},
+    {"", "a\n", "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n", 1, 0, 1},
+    /* A missing final newline is not marked. */
+    {"a\nb", "a\nc", "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n", 1, 1, 1},
+    {"a\nb\nc\n", "c\nb\na\n", "--- old\n+++ new\n@@ -1,3 +1,3 @@\n-a\n-b\n c\n+b\n+a\n", 2, 2, 1},
+  }
+  for _, c := range cases {
+    d := UnifiedDiff("old", c.a, "new", c.b)
+    if d.Text != c.text {
+      t.Errorf("%q -> %q: expected\n%s\ngot\n%s", c.a, c.b, c.text, d.Text)
+    }
+    if d.Added != c.added || d.Removed != c.removed || d.Hunks != c.hunks {
+      t.Errorf("%q -> %q: bad stats %+v", c.a, c.b, *d)
+    }
+  }
+}
+
+func numberedLines(prefix string, n int) string {
+  var b strings.Builder
+  for i := 0; i < n; i++ { fmt.Fprintf(&b, "%s%d\n", prefix, i) }
+  return b.String()
+}
+
+func TestUnifiedDiffLarge(t *testing.T) {
+  /* Unrelated texts: the edit distance is maximal. */
+  d := UnifiedDiff("old", numberedLines("a", 3000), "new", numberedLines("b", 3000))
+  if d.Added != 3000 || d.Removed != 3000 || d.Hunks != 1 {
+    t.Errorf("bad stats %d %d %d", d.Added, d.Removed, d.Hunks)
+  }
+  a := numberedLines("a", 3000)
+  b := strings.Replace(a, "a1500\n", "b1500\n", 1)
+  d = UnifiedDiff("old", a, "new", b)
+  if d.Added != 1 || d.Removed != 1 || d.Hunks != 1 {
+    t.Errorf("bad stats %d %d %d", d.Added, d.Removed, d.Hunks)
+  }
+  /* Texts that are too long are shown as replaced. */
+  a = numberedLines("a", DiffMaxLines / 2)
+  b = a + "a\n"
+  d = UnifiedDiff("old", a, "new", b)
+  if d.Added != DiffMaxLines / 2 + 1 || d.Removed != DiffMaxLines / 2 {
+    t.Errorf("bad stats %d %d %d", d.Added, d.Removed, d.Hunks)
+  }
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+  "errors"
+  "net"
+  "net/http"
+  "syscall"
+  "time"
+)
+
+var ErrNonPublicAddress = errors.New("address is not public")
+
+/* Blocks of addresses that are not reachable on the internet: loopback,
+   private networks, link-local (including cloud metadata endpoints),
+   carrier-grade NAT, multicast and reserved ranges. */
+var nonPublicNets []*net.IPNet
+
+func init() {
+  for _, cidr := range []string{
+    "0.0.0.0/8",
+    "10.0.0.0/8",
+    "100.64.0.0/10",
+    "127.0.0.0/8",
+    "169.254.0.0/16",
+    "172.16.0.0/12",
+    "192.0.0.0/24",
+    "192.168.0.0/16",
+    "198.18.0.0/15",
+    "224.0.0.0/4",
+    "240.0.0.0/4",
+    "::/128",
+    "::1/128",
+    "64:ff9b::/96",
+    "fc00::/7",
+    "fe80::/10",
+    "ff00::/8",
+  } {
+    _, ipNet, err := net.ParseCIDR(cidr)
+    if err != nil { panic(err) }
+    nonPublicNets = append(nonPublicNets, ipNet)
+  }
+}
+
+/* Reports whether ip is a public unicast address. */
+func IsPublicIP(ip net.IP) bool {
+  if ip4 := ip.To4(); ip4 != nil { ip = ip4 }
+  for _, ipNet := range nonPublicNets {
+    if ipNet.Contains(ip) { return false }
+  }
+  return true
+}
+
+/*
+  PublicHttpClient returns a client for requests to URLs supplied by users.
+  Connections to non-public addresses are refused when they are dialed
+  (after name resolution, so a name cannot resolve to an internal address),
+  redirects are not followed, and proxy settings are ignored.
+*/
+func PublicHttpClient(timeout time.Duration) *http.Client {
+  dialer := &net.Dialer{
+    Timeout: timeout,
+    Control: func (network, address string, c syscall.RawConn) error {
+      host, _, err := net.SplitHostPort(address)
+      if err != nil { return err }
+      ip := net.ParseIP(host)
+      if ip == nil || !IsPublicIP(ip) { return ErrNonPublicAddress }
+      return nil
+    },
+  }
+  return &http.Client{
+    Timeout: timeout,
+    Transport: &http.Transport{
+      DialContext: dialer.DialContext,
+      TLSHandshakeTimeout: timeout,
+      MaxIdleConns: 10,
+      IdleConnTimeout: 90 * time.Second,
+    },
+    CheckRedirect: func (req *http.Request, via []*http.Request) error {
+      return http.ErrUseLastResponse
+    },
+  }
+}
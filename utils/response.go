@@ -2,7 +2,7 @@
 package utils
 
 import (
-  //"fmt"
+  "fmt"
   "github.com/gin-gonic/gin"
   "github.com/fatih/color"
   "github.com/go-errors/errors"
@@ -33,6 +33,28 @@ func (r *Response) Result(val j.Value) {
   r.Send(res)
 }
 
+/* Sends a large value, encoding it directly to the connection.  As the
+   status has been sent by the time an encoding error occurs, such errors
+   are only logged and the client gets a truncated document: do not stream
+   responses that can be cached. */
+func (r *Response) SendStream(data j.Value) {
+  r.context.Header("Content-Type", "application/json")
+  r.context.Status(200)
+  enc := j.NewEncoder(r.context.Writer)
+  enc.Value(data)
+  if err := enc.Flush(); err != nil {
+    fmt.Printf("failed to stream response: %v\n", err)
+  }
+}
+
+/* Sends a result along with a receipt signed by the backend. */
+func (r *Response) ResultWithReceipt(val j.Value, receipt j.Value) {
+  res := j.Object()
+  res.Prop("result", val)
+  res.Prop("receipt", receipt)
+  r.Send(res)
+}
+
 func (r *Response) Ok() {
   r.Send(j.Boolean(true))
 }
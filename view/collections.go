@@ -87,3 +87,12 @@ func (v *View) loadTaskResources(taskId int64) error {
   v.Add(fmt.Sprintf("tasks#resources %s", ExportId(taskId)), obj)
   return nil
 }
+
+func (v *View) loadTeamUsage(team *model.Team) error {
+  contest, err := v.model.LoadContest(team.Contest_id)
+  if err != nil { return err }
+  usage, err := v.model.LoadTeamUsage(team.Id)
+  if err != nil { return err }
+  v.addTeamUsage(team.Id, contest, usage)
+  return nil
+}
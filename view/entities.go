@@ -2,8 +2,10 @@
 package view
 
 import (
+  "database/sql"
   "fmt"
   "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/utils"
   j "tezos-contests.izibi.com/backend/jase"
 )
 
@@ -59,6 +61,24 @@ func (v *View) addTeamMember(member *model.TeamMember) string {
   return id
 }
 
+func (v *View) addTeamUsage(teamId int64, contest *model.Contest, usage *model.TeamUsage) string {
+  id := ExportId(teamId)
+  obj := j.Object()
+  obj.Prop("nbPrivateChains", j.Int64(usage.Nb_private_chains))
+  obj.Prop("maxPrivateChains", nullInt64(contest.Max_private_chains))
+  obj.Prop("nbRunningGames", j.Int64(usage.Nb_running_games))
+  obj.Prop("maxRunningGames", nullInt64(contest.Max_running_games))
+  obj.Prop("storeBytes", j.Int64(usage.Store_bytes))
+  obj.Prop("maxStoreBytes", nullInt64(contest.Max_store_bytes))
+  v.Add(fmt.Sprintf("teams#usage %s", id), obj)
+  return id
+}
+
+func nullInt64(n sql.NullInt64) j.Value {
+  if !n.Valid { return j.Null }
+  return j.Int64(n.Int64)
+}
+
 func (v *View) addContest(contest *model.Contest) string {
   id := ExportId(contest.Id)
   obj := j.Object()
@@ -135,6 +155,22 @@ func (v *View) addChain(chain *model.Chain) string {
   return id
 }
 
+/* Placeholder for a chain the view's team is not allowed to see, keeps only
+   what is needed to place it in a tree of chains. */
+func (v *View) addRedactedChain(chain *model.Chain) string {
+  id := ExportId(chain.Id)
+  obj := j.Object()
+  obj.Prop("id", j.String(id))
+  parentId := j.Null
+  if chain.Parent_id.Valid {
+    parentId = j.String(ExportId(chain.Parent_id.Int64))
+  }
+  obj.Prop("parentId", parentId)
+  obj.Prop("isRedacted", j.Boolean(true))
+  v.Add(fmt.Sprintf("chains %s", id), obj)
+  return id
+}
+
 func (v *View) addChainDetails(chain *model.Chain) string {
   id := ExportId(chain.Id)
   obj := j.Object()
@@ -145,3 +181,84 @@ func (v *View) addChainDetails(chain *model.Chain) string {
   v.Add(fmt.Sprintf("chains#details %s", id), obj)
   return id
 }
+
+func (v *View) addChainProtocolDiff(diff *ProtocolDiff) string {
+  id := fmt.Sprintf("%s_%s", ExportId(diff.Chain.Id), ExportId(diff.Base.Id))
+  obj := j.Object()
+  obj.Prop("chainId", j.String(ExportId(diff.Chain.Id)))
+  obj.Prop("baseChainId", j.String(ExportId(diff.Base.Id)))
+  obj.Prop("source", j.String(diff.Source))
+  if diff.Source == "protocol" {
+    obj.Prop("protocolHash", j.String(diff.Chain.Protocol_hash))
+    obj.Prop("baseProtocolHash", j.String(diff.Base.Protocol_hash))
+  }
+  obj.Prop("interface", viewDiff(diff.Interface))
+  obj.Prop("implementation", viewDiff(diff.Implementation))
+  obj.Prop("nbLinesAdded", j.Int(diff.Interface.Added + diff.Implementation.Added))
+  obj.Prop("nbLinesRemoved", j.Int(diff.Interface.Removed + diff.Implementation.Removed))
+  obj.Prop("nbHunks", j.Int(diff.Interface.Hunks + diff.Implementation.Hunks))
+  v.Add(fmt.Sprintf("chains#protocolDiff %s", id), obj)
+  return id
+}
+
+func viewDiff(diff *utils.Diff) j.Value {
+  obj := j.Object()
+  obj.Prop("text", j.String(diff.Text))
+  obj.Prop("nbLinesAdded", j.Int(diff.Added))
+  obj.Prop("nbLinesRemoved", j.Int(diff.Removed))
+  obj.Prop("nbHunks", j.Int(diff.Hunks))
+  return obj
+}
+
+func (v *View) addChainProposal(proposal *model.ChainProposal) string {
+  id := ExportId(proposal.Id)
+  obj := j.Object()
+  obj.Prop("id", j.String(id))
+  obj.Prop("createdAt", j.Time(proposal.Created_at))
+  obj.Prop("updatedAt", j.Time(proposal.Updated_at))
+  obj.Prop("contestId", j.String(ExportId(proposal.Contest_id)))
+  obj.Prop("teamId", j.String(ExportId(proposal.Team_id)))
+  obj.Prop("sourceChainId", j.String(ExportId(proposal.Source_chain_id)))
+  obj.Prop("targetChainId", j.String(ExportId(proposal.Target_chain_id)))
+  obj.Prop("title", j.String(proposal.Title))
+  obj.Prop("description", j.String(proposal.Description))
+  obj.Prop("protocolHash", j.String(proposal.Protocol_hash))
+  obj.Prop("status", j.String(proposal.Status))
+  resolvedAt := j.Null
+  if proposal.Resolved_at.Valid {
+    resolvedAt = j.Time(proposal.Resolved_at.Time)
+  }
+  obj.Prop("resolvedAt", resolvedAt)
+  resolvedBy := j.Null
+  if proposal.Resolved_by.Valid {
+    resolvedBy = j.String(ExportId(proposal.Resolved_by.Int64))
+  }
+  obj.Prop("resolvedBy", resolvedBy)
+  v.Add(fmt.Sprintf("chainProposals %s", id), obj)
+  return id
+}
+
+func (v *View) addChainProposalComment(comment *model.ChainProposalComment) string {
+  id := ExportId(comment.Id)
+  obj := j.Object()
+  obj.Prop("id", j.String(id))
+  obj.Prop("createdAt", j.Time(comment.Created_at))
+  obj.Prop("proposalId", j.String(ExportId(comment.Proposal_id)))
+  obj.Prop("userId", j.String(ExportId(comment.User_id)))
+  obj.Prop("body", j.String(comment.Body))
+  v.Add(fmt.Sprintf("chainProposalComments %s", id), obj)
+  return id
+}
+
+func (v *View) addChainProposalDiff(proposalId int64, baseHash string, intf *utils.Diff, impl *utils.Diff) string {
+  id := ExportId(proposalId)
+  obj := j.Object()
+  obj.Prop("baseProtocolHash", j.String(baseHash))
+  obj.Prop("interface", viewDiff(intf))
+  obj.Prop("implementation", viewDiff(impl))
+  obj.Prop("nbLinesAdded", j.Int(intf.Added + impl.Added))
+  obj.Prop("nbLinesRemoved", j.Int(intf.Removed + impl.Removed))
+  obj.Prop("nbHunks", j.Int(intf.Hunks + impl.Hunks))
+  v.Add(fmt.Sprintf("chainProposals#diff %s", id), obj)
+  return id
+}
@@ -81,3 +81,11 @@ func ExportId(id int64) string {
 func (v *View) SetTeam(teamId int64) {
   v.teamId = teamId
 }
+
+/* Private chains are only visible to their owner team. */
+func (v *View) CanViewChain(chain *model.Chain) bool {
+  if v.isAdmin || model.IsPublicChainStatus(chain.Status_id) {
+    return true
+  }
+  return chain.Owner_id.Valid && chain.Owner_id.Int64 == v.teamId
+}
@@ -2,8 +2,12 @@
 package view
 
 import (
+  "database/sql"
+  "fmt"
+  "time"
   "github.com/go-errors/errors"
   "tezos-contests.izibi.com/backend/model"
+  "tezos-contests.izibi.com/backend/utils"
   j "tezos-contests.izibi.com/backend/jase"
 )
 
@@ -75,14 +79,24 @@ func (v *View) ViewUserContestTeam(userId int64, contestId int64) error {
   if err != nil { return err }
   err = v.loadTeamMembers([]int64{team.Id})
   if err != nil { return err }
+  err = v.loadTeamUsage(team)
+  if err != nil { return err }
   return nil
 }
 
 
 type ChainFilters struct {
   Status string
-  TeamId string
-  TitleSearch string
+  TeamId string /* owner team id, "null" for chains without owner */
+  Search string /* full-text search in title and description */
+  ParentId string
+  CreatedAfter time.Time
+  CreatedBefore time.Time
+  UpdatedAfter time.Time
+  UpdatedBefore time.Time
+  Sort string /* "updated" (default) or "votes" */
+  Cursor string
+  Limit int
 }
 
 func (v *View) ViewChains(userId int64, contestId int64, filters ChainFilters) error {
@@ -94,13 +108,53 @@ func (v *View) ViewChains(userId int64, contestId int64, filters ChainFilters) e
     if team == nil { return errors.New("access denied") }
     v.teamId = team.Id
   }
-  /*
-    TODO: add support for filters:
-    - teamId | null
-    - text (chain title)
-  */
-  chains, err := v.model.LoadContestChains(contestId,
-    model.ChainStatusFilter{Status: filters.Status, TeamId: v.teamId})
+  if filters.Sort == "" {
+    filters.Sort = "updated"
+  }
+  page := model.ChainPage{Cursor: filters.Cursor, Limit: filters.Limit}
+  if page.Limit <= 0 {
+    page.Limit = model.DefaultChainPageSize
+  }
+  if page.Limit > model.MaxChainPageSize {
+    page.Limit = model.MaxChainPageSize
+  }
+  /* Load one extra chain to find out whether there is a next page. */
+  page.Limit += 1
+  modelFilters := []interface{}{
+    model.ChainVisibilityFilter{TeamId: v.teamId},
+    model.ChainStatusFilter{Status: filters.Status, TeamId: v.teamId},
+    model.ChainOrder{By: filters.Sort},
+    page,
+  }
+  switch filters.TeamId {
+  case "":
+  case "null":
+    modelFilters = append(modelFilters, model.ChainOwnerFilter{})
+  default:
+    modelFilters = append(modelFilters, model.ChainOwnerFilter{
+      TeamId: sql.NullInt64{Int64: ImportId(filters.TeamId), Valid: true}})
+  }
+  if filters.Search != "" {
+    modelFilters = append(modelFilters, model.ChainTextFilter{Text: filters.Search})
+  }
+  if filters.ParentId != "" {
+    modelFilters = append(modelFilters, model.ChainParentFilter{ParentId: ImportId(filters.ParentId)})
+  }
+  if !filters.CreatedAfter.IsZero() || !filters.CreatedBefore.IsZero() {
+    modelFilters = append(modelFilters, model.ChainDateFilter{
+      Field: "created", After: filters.CreatedAfter, Before: filters.CreatedBefore})
+  }
+  if !filters.UpdatedAfter.IsZero() || !filters.UpdatedBefore.IsZero() {
+    modelFilters = append(modelFilters, model.ChainDateFilter{
+      Field: "updated", After: filters.UpdatedAfter, Before: filters.UpdatedBefore})
+  }
+  chains, err := v.model.LoadContestChains(contestId, modelFilters...)
+  if err != nil { return err }
+  nextCursor := j.Null
+  if len(chains) == page.Limit {
+    chains = chains[:page.Limit - 1]
+    nextCursor = j.String(model.ChainCursor(&chains[len(chains) - 1], filters.Sort))
+  }
   chainIds := j.Array()
   for i := range chains {
     chain := &chains[i]
@@ -113,6 +167,7 @@ func (v *View) ViewChains(userId int64, contestId int64, filters ChainFilters) e
   err = v.teams.Load(v.loadTeams)
   if err != nil { return errors.Wrap(err, 0) }
   v.Set("chainIds", chainIds)
+  v.Set("nextCursor", nextCursor)
   return nil
 }
 
@@ -133,3 +188,137 @@ func (v *View) ViewChainDetails(chainId int64) error {
   v.addChainDetails(chain)
   return nil
 }
+
+/* Differences between the protocol of Chain and that of Base. */
+type ProtocolDiff struct {
+  Chain *model.Chain
+  Base *model.Chain
+  Source string /* "text" (chain sources) or "protocol" (compiled protocols) */
+  Interface *utils.Diff
+  Implementation *utils.Diff
+}
+
+func (v *View) ViewChainProtocolDiff(diff *ProtocolDiff) error {
+  if !v.CanViewChain(diff.Chain) || !v.CanViewChain(diff.Base) {
+    return errors.New("access denied")
+  }
+  v.addChain(diff.Chain)
+  v.addChainDetails(diff.Chain)
+  v.addChain(diff.Base)
+  v.addChainDetails(diff.Base)
+  v.Set("diffId", j.String(v.addChainProtocolDiff(diff)))
+  return nil
+}
+
+/* View the ancestors and descendants of a chain.  Private chains of other
+   teams keep their place in the tree but their details are not returned. */
+func (v *View) ViewChainLineage(chainId int64) error {
+  chain, err := v.model.LoadChain(chainId)
+  if err != nil { return err }
+  if !v.CanViewChain(chain) { return errors.New("access denied") }
+  ancestors, err := v.model.LoadChainAncestors(chain)
+  if err != nil { return err }
+  descendants, err := v.model.LoadChainDescendants(chainId)
+  if err != nil { return err }
+  ancestorIds := j.Array()
+  for i := range ancestors {
+    ancestorIds.Item(j.String(v.addLineageChain(&ancestors[i])))
+  }
+  descendantIds := j.Array()
+  for i := range descendants {
+    descendantIds.Item(j.String(v.addLineageChain(&descendants[i])))
+  }
+  v.addLineageChain(chain)
+  err = v.teams.Load(v.loadTeams)
+  if err != nil { return err }
+  obj := j.Object()
+  obj.Prop("ancestorIds", ancestorIds)
+  obj.Prop("descendantIds", descendantIds)
+  v.Add(fmt.Sprintf("chains#lineage %s", ExportId(chainId)), obj)
+  v.Set("chainId", j.String(ExportId(chainId)))
+  return nil
+}
+
+func (v *View) addLineageChain(chain *model.Chain) string {
+  if !v.CanViewChain(chain) {
+    return v.addRedactedChain(chain)
+  }
+  if chain.Owner_id.Valid {
+    v.teams.Need(chain.Owner_id.Int64)
+  }
+  return v.addChain(chain)
+}
+
+/* A proposal is visible to its author team, to the owners of its target
+   chain, and to teams that can view both chains. */
+func (v *View) CanViewChainProposal(proposal *model.ChainProposal, source *model.Chain, target *model.Chain) bool {
+  if v.isAdmin || proposal.Team_id == v.teamId {
+    return true
+  }
+  if target.Owner_id.Valid && target.Owner_id.Int64 == v.teamId {
+    return true
+  }
+  return v.CanViewChain(source) && v.CanViewChain(target)
+}
+
+func (v *View) ViewChainProposals(chainId int64) error {
+  chain, err := v.model.LoadChain(chainId)
+  if err != nil { return err }
+  if !v.CanViewChain(chain) { return errors.New("access denied") }
+  proposals, err := v.model.LoadChainProposals(chainId)
+  if err != nil { return err }
+  proposalIds := j.Array()
+  for i := range proposals {
+    proposal := &proposals[i]
+    source, err := v.model.LoadChain(proposal.Source_chain_id)
+    if err != nil { return err }
+    target, err := v.model.LoadChain(proposal.Target_chain_id)
+    if err != nil { return err }
+    if !v.CanViewChainProposal(proposal, source, target) { continue }
+    proposalIds.Item(j.String(v.addChainProposal(proposal)))
+    v.teams.Need(proposal.Team_id)
+  }
+  err = v.teams.Load(v.loadTeams)
+  if err != nil { return err }
+  v.Set("proposalIds", proposalIds)
+  return nil
+}
+
+type ChainProposalDetails struct {
+  Proposal *model.ChainProposal
+  Source *model.Chain
+  Target *model.Chain
+  BaseProtocolHash string /* protocol of the target the diff is relative to */
+  Interface *utils.Diff
+  Implementation *utils.Diff
+}
+
+func (v *View) ViewChainProposal(details *ChainProposalDetails) error {
+  proposal := details.Proposal
+  if !v.CanViewChainProposal(proposal, details.Source, details.Target) {
+    return errors.New("access denied")
+  }
+  comments, err := v.model.LoadChainProposalComments(proposal.Id)
+  if err != nil { return err }
+  v.addChainProposal(proposal)
+  v.addChain(details.Source)
+  v.addChain(details.Target)
+  v.addChainProposalDiff(proposal.Id, details.BaseProtocolHash,
+    details.Interface, details.Implementation)
+  commentIds := j.Array()
+  for i := range comments {
+    comment := &comments[i]
+    commentIds.Item(j.String(v.addChainProposalComment(comment)))
+    v.users.Need(comment.User_id)
+  }
+  obj := j.Object()
+  obj.Prop("commentIds", commentIds)
+  v.Add(fmt.Sprintf("chainProposals#comments %s", ExportId(proposal.Id)), obj)
+  v.teams.Need(proposal.Team_id)
+  err = v.teams.Load(v.loadTeams)
+  if err != nil { return err }
+  err = v.users.Load(v.loadUsers)
+  if err != nil { return err }
+  v.Set("proposalId", j.String(ExportId(proposal.Id)))
+  return nil
+}